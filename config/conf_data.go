@@ -4,9 +4,18 @@ type confData struct {
 	TcpListenerAddress  []string /// [":2423",":5467"]
 	GrpcListenerAddress string
 	LogDir              string
+	DataDir             string /// 本地数据目录 为空时使用LogDir
 	LocalIp             string
 	ProcessName         string
 	Redis               *redis_config
 	Rabbitmq            *rabbitmq_config
 	Nacos               *nacos_config
 }
+
+// GetDataDir 返回本地数据目录
+func (c *confData) GetDataDir() string {
+	if c.DataDir != "" {
+		return c.DataDir
+	}
+	return c.LogDir
+}
@@ -9,4 +9,5 @@ type rabbitmq_config struct {
 	BlacklistExchange        string ///黑名单交换机
 	BlacklistAccesslogQueue  string ///黑名单上报队列
 	AccesslogToInfluxDBQueue string
+	QuotaExhaustedQueue      string ///流量配额耗尽上报队列
 }
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"proxy_server/log"
+	"proxy_server/server"
 )
 
 func gohttp() {
@@ -56,6 +57,20 @@ func gohttp() {
 			result += fmt.Sprint("memStats.GCSys:", memStats.GCSys, " 为垃圾回收器从操作系统获得的内存字节数。\n")                 /// 为垃圾回收器从操作系统获得的内存字节数。
 			result += fmt.Sprint("memStats.OtherSys:", memStats.OtherSys, " 为其他内存管理用途从操作系统获得的内存字节数。\n")        ///  为其他内存管理用途从操作系统获得的内存字节数。
 
+			authDegraded, authDegradedHits := server.AuthDegradedStats()
+			result += fmt.Sprint("auth.Degraded:", authDegraded, " redis不可用 正在使用本地快照降级鉴权\n")
+			result += fmt.Sprint("auth.DegradedHits:", authDegradedHits, " 降级鉴权成功次数\n")
+
+			destRejectTotal, destRejects := server.DestRejectStats()
+			result += fmt.Sprint("dest.RejectTotal:", destRejectTotal, " 目标域名连接数超限拒绝次数\n")
+			for domain, n := range destRejects {
+				result += fmt.Sprint("dest.Reject[", domain, "]:", n, "\n")
+			}
+
+			for rule, n := range server.BlacklistMonitorStats() {
+				result += fmt.Sprint("blacklist.Monitor[", rule, "]:", n, " 观察模式规则命中次数\n")
+			}
+
 			fmt.Fprintf(w, result)
 		})
 
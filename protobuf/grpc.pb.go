@@ -58,17 +58,35 @@ func (*NullMessage) Descriptor() ([]byte, []int) {
 }
 
 type AuthInfo struct {
-	state         protoimpl.MessageState  `protogen:"open.v1"`
-	Username      string                  `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`                                //账号
-	Password      string                  `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`                                //密码
-	ProxyUsername string                  `protobuf:"bytes,3,opt,name=proxy_username,json=proxyUsername,proto3" json:"proxy_username,omitempty"` //代理账号
-	ProxyPassword string                  `protobuf:"bytes,4,opt,name=proxy_password,json=proxyPassword,proto3" json:"proxy_password,omitempty"` //代理密码
-	S5Addr        string                  `protobuf:"bytes,5,opt,name=s5_addr,json=s5Addr,proto3" json:"s5_addr,omitempty"`                      //s5代理地址 ip:端口
-	HttpAddr      string                  `protobuf:"bytes,6,opt,name=http_addr,json=httpAddr,proto3" json:"http_addr,omitempty"`                //http代理  ip:端口
-	UpdateUnix    int64                   `protobuf:"varint,7,opt,name=update_unix,json=updateUnix,proto3" json:"update_unix,omitempty"`
-	Ips           map[string]*NullMessage `protobuf:"bytes,8,rep,name=ips,proto3" json:"ips,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` //ip数组
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state             protoimpl.MessageState  `protogen:"open.v1"`
+	Username          string                  `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`                                //账号
+	Password          string                  `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`                                //密码
+	ProxyUsername     string                  `protobuf:"bytes,3,opt,name=proxy_username,json=proxyUsername,proto3" json:"proxy_username,omitempty"` //代理账号
+	ProxyPassword     string                  `protobuf:"bytes,4,opt,name=proxy_password,json=proxyPassword,proto3" json:"proxy_password,omitempty"` //代理密码
+	S5Addr            string                  `protobuf:"bytes,5,opt,name=s5_addr,json=s5Addr,proto3" json:"s5_addr,omitempty"`                      //s5代理地址 ip:端口
+	HttpAddr          string                  `protobuf:"bytes,6,opt,name=http_addr,json=httpAddr,proto3" json:"http_addr,omitempty"`                //http代理  ip:端口
+	UpdateUnix        int64                   `protobuf:"varint,7,opt,name=update_unix,json=updateUnix,proto3" json:"update_unix,omitempty"`
+	Ips               map[string]*NullMessage `protobuf:"bytes,8,rep,name=ips,proto3" json:"ips,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` //ip数组
+	ValidFrom         int64                   `protobuf:"varint,9,opt,name=valid_from,json=validFrom,proto3" json:"valid_from,omitempty"`                                             //生效时间 unix秒 0表示不限制
+	ExpireUnix        int64                   `protobuf:"varint,10,opt,name=expire_unix,json=expireUnix,proto3" json:"expire_unix,omitempty"`                                         //过期时间 unix秒 0表示永不过期
+	AccessSchedule    []*AccessWindow         `protobuf:"bytes,11,rep,name=access_schedule,json=accessSchedule,proto3" json:"access_schedule,omitempty"`                              //每周可用时间段 为空表示不限制
+	AllowClientCidrs  []string                `protobuf:"bytes,12,rep,name=allow_client_cidrs,json=allowClientCidrs,proto3" json:"allow_client_cidrs,omitempty"`                      //允许的客户端来源网段 支持单个ip 为空表示不限制
+	Parent            string                  `protobuf:"bytes,13,opt,name=parent,proto3" json:"parent,omitempty"`                                                                    //上级账号 为空表示没有上级 上级账号的限制作用于其全部下级
+	MaxConn           int32                   `protobuf:"varint,14,opt,name=max_conn,json=maxConn,proto3" json:"max_conn,omitempty"`                                                  //账号最大并发连接数 0使用全局配置
+	AccountReadRate   int64                   `protobuf:"varint,15,opt,name=account_read_rate,json=accountReadRate,proto3" json:"account_read_rate,omitempty"`                        //账号整体限速 字节/秒 0表示不限制
+	AccountReadBurst  int64                   `protobuf:"varint,16,opt,name=account_read_burst,json=accountReadBurst,proto3" json:"account_read_burst,omitempty"`                     //账号整体突发流量 字节
+	DailyQuotaBytes   int64                   `protobuf:"varint,17,opt,name=daily_quota_bytes,json=dailyQuotaBytes,proto3" json:"daily_quota_bytes,omitempty"`                        //每日流量配额 字节 0表示不限制
+	MonthlyQuotaBytes int64                   `protobuf:"varint,18,opt,name=monthly_quota_bytes,json=monthlyQuotaBytes,proto3" json:"monthly_quota_bytes,omitempty"`                  //每月流量配额 字节 0表示不限制
+	NewConnRate       int32                   `protobuf:"varint,19,opt,name=new_conn_rate,json=newConnRate,proto3" json:"new_conn_rate,omitempty"`                                    //每秒最多新建连接数 0使用全局配置
+	ReadRate          int64                   `protobuf:"varint,20,opt,name=read_rate,json=readRate,proto3" json:"read_rate,omitempty"`                                               //单个出口ip连接的上下行限速 字节/秒 0使用全局配置
+	ReadBurst         int64                   `protobuf:"varint,21,opt,name=read_burst,json=readBurst,proto3" json:"read_burst,omitempty"`                                            //单个出口ip连接的突发流量 字节 0使用全局配置
+	ServiceClass      string                  `protobuf:"bytes,22,opt,name=service_class,json=serviceClass,proto3" json:"service_class,omitempty"`                                    //服务等级 如premium、standard、bulk 节点带宽紧张时按等级权重分配 为空使用全局默认
+	AllowRules        []*BlacklistRule        `protobuf:"bytes,23,rep,name=allow_rules,json=allowRules,proto3" json:"allow_rules,omitempty"`                                          //允许访问的目标 不为空时只能访问命中的目标 在全局黑名单之后检测
+	DenyRules         []*BlacklistRule        `protobuf:"bytes,24,rep,name=deny_rules,json=denyRules,proto3" json:"deny_rules,omitempty"`                                             //额外禁止访问的目标 在全局黑名单之后检测
+	AllowPorts        []int32                 `protobuf:"varint,25,rep,packed,name=allow_ports,json=allowPorts,proto3" json:"allow_ports,omitempty"`                                  //允许访问的目标端口 不为空时覆盖全局端口策略 只能访问列表中的端口
+	DenyPorts         []int32                 `protobuf:"varint,26,rep,packed,name=deny_ports,json=denyPorts,proto3" json:"deny_ports,omitempty"`                                     //额外禁止访问的目标端口 优先于允许列表
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
 }
 
 func (x *AuthInfo) Reset() {
@@ -157,6 +175,278 @@ func (x *AuthInfo) GetIps() map[string]*NullMessage {
 	return nil
 }
 
+func (x *AuthInfo) GetValidFrom() int64 {
+	if x != nil {
+		return x.ValidFrom
+	}
+	return 0
+}
+
+func (x *AuthInfo) GetExpireUnix() int64 {
+	if x != nil {
+		return x.ExpireUnix
+	}
+	return 0
+}
+
+func (x *AuthInfo) GetAccessSchedule() []*AccessWindow {
+	if x != nil {
+		return x.AccessSchedule
+	}
+	return nil
+}
+
+func (x *AuthInfo) GetAllowClientCidrs() []string {
+	if x != nil {
+		return x.AllowClientCidrs
+	}
+	return nil
+}
+
+func (x *AuthInfo) GetParent() string {
+	if x != nil {
+		return x.Parent
+	}
+	return ""
+}
+
+func (x *AuthInfo) GetMaxConn() int32 {
+	if x != nil {
+		return x.MaxConn
+	}
+	return 0
+}
+
+func (x *AuthInfo) GetAccountReadRate() int64 {
+	if x != nil {
+		return x.AccountReadRate
+	}
+	return 0
+}
+
+func (x *AuthInfo) GetAccountReadBurst() int64 {
+	if x != nil {
+		return x.AccountReadBurst
+	}
+	return 0
+}
+
+func (x *AuthInfo) GetDailyQuotaBytes() int64 {
+	if x != nil {
+		return x.DailyQuotaBytes
+	}
+	return 0
+}
+
+func (x *AuthInfo) GetMonthlyQuotaBytes() int64 {
+	if x != nil {
+		return x.MonthlyQuotaBytes
+	}
+	return 0
+}
+
+func (x *AuthInfo) GetNewConnRate() int32 {
+	if x != nil {
+		return x.NewConnRate
+	}
+	return 0
+}
+
+func (x *AuthInfo) GetReadRate() int64 {
+	if x != nil {
+		return x.ReadRate
+	}
+	return 0
+}
+
+func (x *AuthInfo) GetReadBurst() int64 {
+	if x != nil {
+		return x.ReadBurst
+	}
+	return 0
+}
+
+func (x *AuthInfo) GetServiceClass() string {
+	if x != nil {
+		return x.ServiceClass
+	}
+	return ""
+}
+
+func (x *AuthInfo) GetAllowRules() []*BlacklistRule {
+	if x != nil {
+		return x.AllowRules
+	}
+	return nil
+}
+
+func (x *AuthInfo) GetDenyRules() []*BlacklistRule {
+	if x != nil {
+		return x.DenyRules
+	}
+	return nil
+}
+
+func (x *AuthInfo) GetAllowPorts() []int32 {
+	if x != nil {
+		return x.AllowPorts
+	}
+	return nil
+}
+
+func (x *AuthInfo) GetDenyPorts() []int32 {
+	if x != nil {
+		return x.DenyPorts
+	}
+	return nil
+}
+
+// 每周可用时间段 按服务器本地时区计算
+type AccessWindow struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Weekday       int32                  `protobuf:"varint,1,opt,name=weekday,proto3" json:"weekday,omitempty"`                            //星期几 0为周日
+	StartMinute   int32                  `protobuf:"varint,2,opt,name=start_minute,json=startMinute,proto3" json:"start_minute,omitempty"` //当天开始分钟 包含
+	EndMinute     int32                  `protobuf:"varint,3,opt,name=end_minute,json=endMinute,proto3" json:"end_minute,omitempty"`       //当天结束分钟 不包含 最大1440
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AccessWindow) Reset() {
+	*x = AccessWindow{}
+	mi := &file_protocol_grpc_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AccessWindow) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AccessWindow) ProtoMessage() {}
+
+func (x *AccessWindow) ProtoReflect() protoreflect.Message {
+	mi := &file_protocol_grpc_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AccessWindow.ProtoReflect.Descriptor instead.
+func (*AccessWindow) Descriptor() ([]byte, []int) {
+	return file_protocol_grpc_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *AccessWindow) GetWeekday() int32 {
+	if x != nil {
+		return x.Weekday
+	}
+	return 0
+}
+
+func (x *AccessWindow) GetStartMinute() int32 {
+	if x != nil {
+		return x.StartMinute
+	}
+	return 0
+}
+
+func (x *AccessWindow) GetEndMinute() int32 {
+	if x != nil {
+		return x.EndMinute
+	}
+	return 0
+}
+
+// 代理鉴权令牌 通过SetAuthToken队列签发及吊销
+type AuthToken struct {
+	state         protoimpl.MessageState  `protogen:"open.v1"`
+	Token         string                  `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`                                                                       //令牌
+	Username      string                  `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`                                                                 //所属账号
+	ExpireUnix    int64                   `protobuf:"varint,3,opt,name=expire_unix,json=expireUnix,proto3" json:"expire_unix,omitempty"`                                          //过期时间 unix秒 0表示永不过期
+	Ips           map[string]*NullMessage `protobuf:"bytes,4,rep,name=ips,proto3" json:"ips,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` //允许使用的出口ip 为空表示不限制
+	Revoke        bool                    `protobuf:"varint,5,opt,name=revoke,proto3" json:"revoke,omitempty"`                                                                    //为true时吊销该令牌
+	UpdateUnix    int64                   `protobuf:"varint,6,opt,name=update_unix,json=updateUnix,proto3" json:"update_unix,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuthToken) Reset() {
+	*x = AuthToken{}
+	mi := &file_protocol_grpc_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuthToken) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuthToken) ProtoMessage() {}
+
+func (x *AuthToken) ProtoReflect() protoreflect.Message {
+	mi := &file_protocol_grpc_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuthToken.ProtoReflect.Descriptor instead.
+func (*AuthToken) Descriptor() ([]byte, []int) {
+	return file_protocol_grpc_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *AuthToken) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *AuthToken) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *AuthToken) GetExpireUnix() int64 {
+	if x != nil {
+		return x.ExpireUnix
+	}
+	return 0
+}
+
+func (x *AuthToken) GetIps() map[string]*NullMessage {
+	if x != nil {
+		return x.Ips
+	}
+	return nil
+}
+
+func (x *AuthToken) GetRevoke() bool {
+	if x != nil {
+		return x.Revoke
+	}
+	return false
+}
+
+func (x *AuthToken) GetUpdateUnix() int64 {
+	if x != nil {
+		return x.UpdateUnix
+	}
+	return 0
+}
+
 type DisconnectInfo struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"` //账号
@@ -167,7 +457,7 @@ type DisconnectInfo struct {
 
 func (x *DisconnectInfo) Reset() {
 	*x = DisconnectInfo{}
-	mi := &file_protocol_grpc_proto_msgTypes[2]
+	mi := &file_protocol_grpc_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -179,7 +469,7 @@ func (x *DisconnectInfo) String() string {
 func (*DisconnectInfo) ProtoMessage() {}
 
 func (x *DisconnectInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_protocol_grpc_proto_msgTypes[2]
+	mi := &file_protocol_grpc_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -192,7 +482,7 @@ func (x *DisconnectInfo) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DisconnectInfo.ProtoReflect.Descriptor instead.
 func (*DisconnectInfo) Descriptor() ([]byte, []int) {
-	return file_protocol_grpc_proto_rawDescGZIP(), []int{2}
+	return file_protocol_grpc_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *DisconnectInfo) GetUsername() string {
@@ -209,19 +499,97 @@ func (x *DisconnectInfo) GetIps() []string {
 	return nil
 }
 
+// 流量配额耗尽事件
+type QuotaExhaustedEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`                        //账号
+	Period        string                 `protobuf:"bytes,2,opt,name=period,proto3" json:"period,omitempty"`                            //配额周期 day 或 month
+	UsedBytes     int64                  `protobuf:"varint,3,opt,name=used_bytes,json=usedBytes,proto3" json:"used_bytes,omitempty"`    //已使用流量 字节
+	QuotaBytes    int64                  `protobuf:"varint,4,opt,name=quota_bytes,json=quotaBytes,proto3" json:"quota_bytes,omitempty"` //配额 字节
+	Ts            int64                  `protobuf:"varint,5,opt,name=ts,proto3" json:"ts,omitempty"`                                   //触发时间 unix秒
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QuotaExhaustedEvent) Reset() {
+	*x = QuotaExhaustedEvent{}
+	mi := &file_protocol_grpc_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QuotaExhaustedEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QuotaExhaustedEvent) ProtoMessage() {}
+
+func (x *QuotaExhaustedEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_protocol_grpc_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QuotaExhaustedEvent.ProtoReflect.Descriptor instead.
+func (*QuotaExhaustedEvent) Descriptor() ([]byte, []int) {
+	return file_protocol_grpc_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *QuotaExhaustedEvent) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *QuotaExhaustedEvent) GetPeriod() string {
+	if x != nil {
+		return x.Period
+	}
+	return ""
+}
+
+func (x *QuotaExhaustedEvent) GetUsedBytes() int64 {
+	if x != nil {
+		return x.UsedBytes
+	}
+	return 0
+}
+
+func (x *QuotaExhaustedEvent) GetQuotaBytes() int64 {
+	if x != nil {
+		return x.QuotaBytes
+	}
+	return 0
+}
+
+func (x *QuotaExhaustedEvent) GetTs() int64 {
+	if x != nil {
+		return x.Ts
+	}
+	return 0
+}
+
 type BlackListAccessLog struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Site          string                 `protobuf:"bytes,1,opt,name=site,proto3" json:"site,omitempty"`                                   // 网址
 	AccountType   int32                  `protobuf:"varint,2,opt,name=account_type,json=accountType,proto3" json:"account_type,omitempty"` // 账号类型 0 动态 1 静态
 	Account       string                 `protobuf:"bytes,3,opt,name=account,proto3" json:"account,omitempty"`                             //账号
 	ExitIp        string                 `protobuf:"bytes,4,opt,name=exit_ip,json=exitIp,proto3" json:"exit_ip,omitempty"`                 // 出口ip
+	Monitor       bool                   `protobuf:"varint,5,opt,name=monitor,proto3" json:"monitor,omitempty"`                            // 观察模式命中 连接未被拦截
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *BlackListAccessLog) Reset() {
 	*x = BlackListAccessLog{}
-	mi := &file_protocol_grpc_proto_msgTypes[3]
+	mi := &file_protocol_grpc_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -233,7 +601,7 @@ func (x *BlackListAccessLog) String() string {
 func (*BlackListAccessLog) ProtoMessage() {}
 
 func (x *BlackListAccessLog) ProtoReflect() protoreflect.Message {
-	mi := &file_protocol_grpc_proto_msgTypes[3]
+	mi := &file_protocol_grpc_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -246,7 +614,7 @@ func (x *BlackListAccessLog) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use BlackListAccessLog.ProtoReflect.Descriptor instead.
 func (*BlackListAccessLog) Descriptor() ([]byte, []int) {
-	return file_protocol_grpc_proto_rawDescGZIP(), []int{3}
+	return file_protocol_grpc_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *BlackListAccessLog) GetSite() string {
@@ -277,12 +645,205 @@ func (x *BlackListAccessLog) GetExitIp() string {
 	return ""
 }
 
+func (x *BlackListAccessLog) GetMonitor() bool {
+	if x != nil {
+		return x.Monitor
+	}
+	return false
+}
+
+// 黑名单规则
+type BlacklistRule struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`               //规则标识 命中时上报 为空时使用 类型:值[:端口][@协议]
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`           //domain精确域名 wildcard通配(*.example.com 只匹配子域名) suffix域名及其子域名 regex正则 cidr ip或网段 port端口
+	Value         string                 `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`         //规则内容 type为port时为空
+	Ports         []int32                `protobuf:"varint,4,rep,packed,name=ports,proto3" json:"ports,omitempty"` //限定端口 为空表示不限制 type为port时即要屏蔽的端口
+	Protocol      string                 `protobuf:"bytes,5,opt,name=protocol,proto3" json:"protocol,omitempty"`   //限定协议 http或tls 为空表示不限制
+	Monitor       bool                   `protobuf:"varint,6,opt,name=monitor,proto3" json:"monitor,omitempty"`    //观察模式 命中时只上报不拦截 用于新规则上线前评估影响
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BlacklistRule) Reset() {
+	*x = BlacklistRule{}
+	mi := &file_protocol_grpc_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BlacklistRule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlacklistRule) ProtoMessage() {}
+
+func (x *BlacklistRule) ProtoReflect() protoreflect.Message {
+	mi := &file_protocol_grpc_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlacklistRule.ProtoReflect.Descriptor instead.
+func (*BlacklistRule) Descriptor() ([]byte, []int) {
+	return file_protocol_grpc_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *BlacklistRule) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *BlacklistRule) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *BlacklistRule) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *BlacklistRule) GetPorts() []int32 {
+	if x != nil {
+		return x.Ports
+	}
+	return nil
+}
+
+func (x *BlacklistRule) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *BlacklistRule) GetMonitor() bool {
+	if x != nil {
+		return x.Monitor
+	}
+	return false
+}
+
+// 黑名单广播消息 支持json及protobuf(content_type为application/x-protobuf)
+type BlacklistRuleSet struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Version         int32                  `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"` //格式版本 0或1为旧版域名列表 2支持规则 3支持增量更新
+	Ts              int64                  `protobuf:"varint,2,opt,name=ts,proto3" json:"ts,omitempty"`
+	Rules           []*BlacklistRule       `protobuf:"bytes,3,rep,name=rules,proto3" json:"rules,omitempty"`                                            //全量时为全部规则 增量时为新增的规则
+	Blacklist       []string               `protobuf:"bytes,4,rep,name=blacklist,proto3" json:"blacklist,omitempty"`                                    //旧版域名列表 按suffix规则处理
+	ListVersion     int64                  `protobuf:"varint,5,opt,name=list_version,json=listVersion,proto3" json:"list_version,omitempty"`            //名单版本 每次变更加1 为0时按ts判断新旧
+	Delta           bool                   `protobuf:"varint,6,opt,name=delta,proto3" json:"delta,omitempty"`                                           //为true时是在list_version-1的基础上的增量
+	RemoveRules     []*BlacklistRule       `protobuf:"bytes,7,rep,name=remove_rules,json=removeRules,proto3" json:"remove_rules,omitempty"`             //增量时删除的规则 有id时按id删除 否则按内容删除
+	RemoveBlacklist []string               `protobuf:"bytes,8,rep,name=remove_blacklist,json=removeBlacklist,proto3" json:"remove_blacklist,omitempty"` //增量时删除的旧版域名
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *BlacklistRuleSet) Reset() {
+	*x = BlacklistRuleSet{}
+	mi := &file_protocol_grpc_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BlacklistRuleSet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlacklistRuleSet) ProtoMessage() {}
+
+func (x *BlacklistRuleSet) ProtoReflect() protoreflect.Message {
+	mi := &file_protocol_grpc_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlacklistRuleSet.ProtoReflect.Descriptor instead.
+func (*BlacklistRuleSet) Descriptor() ([]byte, []int) {
+	return file_protocol_grpc_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *BlacklistRuleSet) GetVersion() int32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *BlacklistRuleSet) GetTs() int64 {
+	if x != nil {
+		return x.Ts
+	}
+	return 0
+}
+
+func (x *BlacklistRuleSet) GetRules() []*BlacklistRule {
+	if x != nil {
+		return x.Rules
+	}
+	return nil
+}
+
+func (x *BlacklistRuleSet) GetBlacklist() []string {
+	if x != nil {
+		return x.Blacklist
+	}
+	return nil
+}
+
+func (x *BlacklistRuleSet) GetListVersion() int64 {
+	if x != nil {
+		return x.ListVersion
+	}
+	return 0
+}
+
+func (x *BlacklistRuleSet) GetDelta() bool {
+	if x != nil {
+		return x.Delta
+	}
+	return false
+}
+
+func (x *BlacklistRuleSet) GetRemoveRules() []*BlacklistRule {
+	if x != nil {
+		return x.RemoveRules
+	}
+	return nil
+}
+
+func (x *BlacklistRuleSet) GetRemoveBlacklist() []string {
+	if x != nil {
+		return x.RemoveBlacklist
+	}
+	return nil
+}
+
 var File_protocol_grpc_proto protoreflect.FileDescriptor
 
 var file_protocol_grpc_proto_rawDesc = string([]byte{
 	0x0a, 0x13, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2f, 0x67, 0x72, 0x70, 0x63, 0x2e,
 	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x0d, 0x0a, 0x0b, 0x4e, 0x75, 0x6c, 0x6c, 0x4d, 0x65, 0x73,
-	0x73, 0x61, 0x67, 0x65, 0x22, 0xd3, 0x02, 0x0a, 0x08, 0x41, 0x75, 0x74, 0x68, 0x49, 0x6e, 0x66,
+	0x73, 0x61, 0x67, 0x65, 0x22, 0x87, 0x08, 0x0a, 0x08, 0x41, 0x75, 0x74, 0x68, 0x49, 0x6e, 0x66,
 	0x6f, 0x12, 0x1a, 0x0a, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20,
 	0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1a, 0x0a,
 	0x08, 0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
@@ -299,23 +860,128 @@ var file_protocol_grpc_proto_rawDesc = string([]byte{
 	0x28, 0x03, 0x52, 0x0a, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x55, 0x6e, 0x69, 0x78, 0x12, 0x24,
 	0x0a, 0x03, 0x69, 0x70, 0x73, 0x18, 0x08, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x41, 0x75,
 	0x74, 0x68, 0x49, 0x6e, 0x66, 0x6f, 0x2e, 0x49, 0x70, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52,
-	0x03, 0x69, 0x70, 0x73, 0x1a, 0x44, 0x0a, 0x08, 0x49, 0x70, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
-	0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b,
-	0x65, 0x79, 0x12, 0x22, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x0b, 0x32, 0x0c, 0x2e, 0x4e, 0x75, 0x6c, 0x6c, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x52,
-	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x3e, 0x0a, 0x0e, 0x44, 0x69,
-	0x73, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x1a, 0x0a, 0x08,
-	0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
-	0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x69, 0x70, 0x73, 0x18,
-	0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x69, 0x70, 0x73, 0x22, 0x7e, 0x0a, 0x12, 0x42, 0x6c,
-	0x61, 0x63, 0x6b, 0x4c, 0x69, 0x73, 0x74, 0x41, 0x63, 0x63, 0x65, 0x73, 0x73, 0x4c, 0x6f, 0x67,
-	0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04,
-	0x73, 0x69, 0x74, 0x65, 0x12, 0x21, 0x0a, 0x0c, 0x61, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x5f,
-	0x74, 0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b, 0x61, 0x63, 0x63, 0x6f,
-	0x75, 0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x63, 0x63, 0x6f, 0x75,
-	0x6e, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x63, 0x63, 0x6f, 0x75, 0x6e,
-	0x74, 0x12, 0x17, 0x0a, 0x07, 0x65, 0x78, 0x69, 0x74, 0x5f, 0x69, 0x70, 0x18, 0x04, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x06, 0x65, 0x78, 0x69, 0x74, 0x49, 0x70, 0x32, 0xd3, 0x01, 0x0a, 0x04, 0x41,
+	0x03, 0x69, 0x70, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x5f, 0x66, 0x72,
+	0x6f, 0x6d, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x46,
+	0x72, 0x6f, 0x6d, 0x12, 0x1f, 0x0a, 0x0b, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x5f, 0x75, 0x6e,
+	0x69, 0x78, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65,
+	0x55, 0x6e, 0x69, 0x78, 0x12, 0x36, 0x0a, 0x0f, 0x61, 0x63, 0x63, 0x65, 0x73, 0x73, 0x5f, 0x73,
+	0x63, 0x68, 0x65, 0x64, 0x75, 0x6c, 0x65, 0x18, 0x0b, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0d, 0x2e,
+	0x41, 0x63, 0x63, 0x65, 0x73, 0x73, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x52, 0x0e, 0x61, 0x63,
+	0x63, 0x65, 0x73, 0x73, 0x53, 0x63, 0x68, 0x65, 0x64, 0x75, 0x6c, 0x65, 0x12, 0x2c, 0x0a, 0x12,
+	0x61, 0x6c, 0x6c, 0x6f, 0x77, 0x5f, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f, 0x63, 0x69, 0x64,
+	0x72, 0x73, 0x18, 0x0c, 0x20, 0x03, 0x28, 0x09, 0x52, 0x10, 0x61, 0x6c, 0x6c, 0x6f, 0x77, 0x43,
+	0x6c, 0x69, 0x65, 0x6e, 0x74, 0x43, 0x69, 0x64, 0x72, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x61,
+	0x72, 0x65, 0x6e, 0x74, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x61, 0x72, 0x65,
+	0x6e, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x6d, 0x61, 0x78, 0x5f, 0x63, 0x6f, 0x6e, 0x6e, 0x18, 0x0e,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x6d, 0x61, 0x78, 0x43, 0x6f, 0x6e, 0x6e, 0x12, 0x2a, 0x0a,
+	0x11, 0x61, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x5f, 0x72, 0x65, 0x61, 0x64, 0x5f, 0x72, 0x61,
+	0x74, 0x65, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x61, 0x63, 0x63, 0x6f, 0x75, 0x6e,
+	0x74, 0x52, 0x65, 0x61, 0x64, 0x52, 0x61, 0x74, 0x65, 0x12, 0x2c, 0x0a, 0x12, 0x61, 0x63, 0x63,
+	0x6f, 0x75, 0x6e, 0x74, 0x5f, 0x72, 0x65, 0x61, 0x64, 0x5f, 0x62, 0x75, 0x72, 0x73, 0x74, 0x18,
+	0x10, 0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x61, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x52, 0x65,
+	0x61, 0x64, 0x42, 0x75, 0x72, 0x73, 0x74, 0x12, 0x2a, 0x0a, 0x11, 0x64, 0x61, 0x69, 0x6c, 0x79,
+	0x5f, 0x71, 0x75, 0x6f, 0x74, 0x61, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x11, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0f, 0x64, 0x61, 0x69, 0x6c, 0x79, 0x51, 0x75, 0x6f, 0x74, 0x61, 0x42, 0x79,
+	0x74, 0x65, 0x73, 0x12, 0x2e, 0x0a, 0x13, 0x6d, 0x6f, 0x6e, 0x74, 0x68, 0x6c, 0x79, 0x5f, 0x71,
+	0x75, 0x6f, 0x74, 0x61, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x12, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x11, 0x6d, 0x6f, 0x6e, 0x74, 0x68, 0x6c, 0x79, 0x51, 0x75, 0x6f, 0x74, 0x61, 0x42, 0x79,
+	0x74, 0x65, 0x73, 0x12, 0x22, 0x0a, 0x0d, 0x6e, 0x65, 0x77, 0x5f, 0x63, 0x6f, 0x6e, 0x6e, 0x5f,
+	0x72, 0x61, 0x74, 0x65, 0x18, 0x13, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b, 0x6e, 0x65, 0x77, 0x43,
+	0x6f, 0x6e, 0x6e, 0x52, 0x61, 0x74, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x72, 0x65, 0x61, 0x64, 0x5f,
+	0x72, 0x61, 0x74, 0x65, 0x18, 0x14, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x72, 0x65, 0x61, 0x64,
+	0x52, 0x61, 0x74, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x72, 0x65, 0x61, 0x64, 0x5f, 0x62, 0x75, 0x72,
+	0x73, 0x74, 0x18, 0x15, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x72, 0x65, 0x61, 0x64, 0x42, 0x75,
+	0x72, 0x73, 0x74, 0x12, 0x23, 0x0a, 0x0d, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x5f, 0x63,
+	0x6c, 0x61, 0x73, 0x73, 0x18, 0x16, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x73, 0x65, 0x72, 0x76,
+	0x69, 0x63, 0x65, 0x43, 0x6c, 0x61, 0x73, 0x73, 0x12, 0x2f, 0x0a, 0x0b, 0x61, 0x6c, 0x6c, 0x6f,
+	0x77, 0x5f, 0x72, 0x75, 0x6c, 0x65, 0x73, 0x18, 0x17, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0e, 0x2e,
+	0x42, 0x6c, 0x61, 0x63, 0x6b, 0x6c, 0x69, 0x73, 0x74, 0x52, 0x75, 0x6c, 0x65, 0x52, 0x0a, 0x61,
+	0x6c, 0x6c, 0x6f, 0x77, 0x52, 0x75, 0x6c, 0x65, 0x73, 0x12, 0x2d, 0x0a, 0x0a, 0x64, 0x65, 0x6e,
+	0x79, 0x5f, 0x72, 0x75, 0x6c, 0x65, 0x73, 0x18, 0x18, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0e, 0x2e,
+	0x42, 0x6c, 0x61, 0x63, 0x6b, 0x6c, 0x69, 0x73, 0x74, 0x52, 0x75, 0x6c, 0x65, 0x52, 0x09, 0x64,
+	0x65, 0x6e, 0x79, 0x52, 0x75, 0x6c, 0x65, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x61, 0x6c, 0x6c, 0x6f,
+	0x77, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x18, 0x19, 0x20, 0x03, 0x28, 0x05, 0x52, 0x0a, 0x61,
+	0x6c, 0x6c, 0x6f, 0x77, 0x50, 0x6f, 0x72, 0x74, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x64, 0x65, 0x6e,
+	0x79, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x18, 0x1a, 0x20, 0x03, 0x28, 0x05, 0x52, 0x09, 0x64,
+	0x65, 0x6e, 0x79, 0x50, 0x6f, 0x72, 0x74, 0x73, 0x1a, 0x44, 0x0a, 0x08, 0x49, 0x70, 0x73, 0x45,
+	0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x22, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x4e, 0x75, 0x6c, 0x6c, 0x4d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x6a,
+	0x0a, 0x0c, 0x41, 0x63, 0x63, 0x65, 0x73, 0x73, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x12, 0x18,
+	0x0a, 0x07, 0x77, 0x65, 0x65, 0x6b, 0x64, 0x61, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x07, 0x77, 0x65, 0x65, 0x6b, 0x64, 0x61, 0x79, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x74, 0x61, 0x72,
+	0x74, 0x5f, 0x6d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b,
+	0x73, 0x74, 0x61, 0x72, 0x74, 0x4d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x65,
+	0x6e, 0x64, 0x5f, 0x6d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x09, 0x65, 0x6e, 0x64, 0x4d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x22, 0x84, 0x02, 0x0a, 0x09, 0x41,
+	0x75, 0x74, 0x68, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x6b, 0x65,
+	0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x1a,
+	0x0a, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x65, 0x78,
+	0x70, 0x69, 0x72, 0x65, 0x5f, 0x75, 0x6e, 0x69, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x55, 0x6e, 0x69, 0x78, 0x12, 0x25, 0x0a, 0x03, 0x69,
+	0x70, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x41, 0x75, 0x74, 0x68, 0x54,
+	0x6f, 0x6b, 0x65, 0x6e, 0x2e, 0x49, 0x70, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x03, 0x69,
+	0x70, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x76, 0x6f, 0x6b, 0x65, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x06, 0x72, 0x65, 0x76, 0x6f, 0x6b, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x75, 0x70,
+	0x64, 0x61, 0x74, 0x65, 0x5f, 0x75, 0x6e, 0x69, 0x78, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0a, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x55, 0x6e, 0x69, 0x78, 0x1a, 0x44, 0x0a, 0x08, 0x49,
+	0x70, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x22, 0x0a, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x4e, 0x75, 0x6c, 0x6c, 0x4d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38,
+	0x01, 0x22, 0x3e, 0x0a, 0x0e, 0x44, 0x69, 0x73, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x49,
+	0x6e, 0x66, 0x6f, 0x12, 0x1a, 0x0a, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x12,
+	0x10, 0x0a, 0x03, 0x69, 0x70, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x69, 0x70,
+	0x73, 0x22, 0x99, 0x01, 0x0a, 0x13, 0x51, 0x75, 0x6f, 0x74, 0x61, 0x45, 0x78, 0x68, 0x61, 0x75,
+	0x73, 0x74, 0x65, 0x64, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x75, 0x73, 0x65,
+	0x72, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x73, 0x65,
+	0x72, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x12, 0x1d, 0x0a,
+	0x0a, 0x75, 0x73, 0x65, 0x64, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x09, 0x75, 0x73, 0x65, 0x64, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x1f, 0x0a, 0x0b,
+	0x71, 0x75, 0x6f, 0x74, 0x61, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x0a, 0x71, 0x75, 0x6f, 0x74, 0x61, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x0e, 0x0a,
+	0x02, 0x74, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x74, 0x73, 0x22, 0x98, 0x01,
+	0x0a, 0x12, 0x42, 0x6c, 0x61, 0x63, 0x6b, 0x4c, 0x69, 0x73, 0x74, 0x41, 0x63, 0x63, 0x65, 0x73,
+	0x73, 0x4c, 0x6f, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x73, 0x69, 0x74, 0x65, 0x12, 0x21, 0x0a, 0x0c, 0x61, 0x63, 0x63, 0x6f,
+	0x75, 0x6e, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0b,
+	0x61, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x61,
+	0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x63,
+	0x63, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x17, 0x0a, 0x07, 0x65, 0x78, 0x69, 0x74, 0x5f, 0x69, 0x70,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x65, 0x78, 0x69, 0x74, 0x49, 0x70, 0x12, 0x18,
+	0x0a, 0x07, 0x6d, 0x6f, 0x6e, 0x69, 0x74, 0x6f, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x07, 0x6d, 0x6f, 0x6e, 0x69, 0x74, 0x6f, 0x72, 0x22, 0x95, 0x01, 0x0a, 0x0d, 0x42, 0x6c, 0x61,
+	0x63, 0x6b, 0x6c, 0x69, 0x73, 0x74, 0x52, 0x75, 0x6c, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79,
+	0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x14,
+	0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x18, 0x04, 0x20,
+	0x03, 0x28, 0x05, 0x52, 0x05, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x12, 0x18, 0x0a, 0x07, 0x6d, 0x6f, 0x6e, 0x69, 0x74, 0x6f,
+	0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x6d, 0x6f, 0x6e, 0x69, 0x74, 0x6f, 0x72,
+	0x22, 0x97, 0x02, 0x0a, 0x10, 0x42, 0x6c, 0x61, 0x63, 0x6b, 0x6c, 0x69, 0x73, 0x74, 0x52, 0x75,
+	0x6c, 0x65, 0x53, 0x65, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12,
+	0x0e, 0x0a, 0x02, 0x74, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x74, 0x73, 0x12,
+	0x24, 0x0a, 0x05, 0x72, 0x75, 0x6c, 0x65, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0e,
+	0x2e, 0x42, 0x6c, 0x61, 0x63, 0x6b, 0x6c, 0x69, 0x73, 0x74, 0x52, 0x75, 0x6c, 0x65, 0x52, 0x05,
+	0x72, 0x75, 0x6c, 0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x62, 0x6c, 0x61, 0x63, 0x6b, 0x6c, 0x69,
+	0x73, 0x74, 0x18, 0x04, 0x20, 0x03, 0x28, 0x09, 0x52, 0x09, 0x62, 0x6c, 0x61, 0x63, 0x6b, 0x6c,
+	0x69, 0x73, 0x74, 0x12, 0x21, 0x0a, 0x0c, 0x6c, 0x69, 0x73, 0x74, 0x5f, 0x76, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x6c, 0x69, 0x73, 0x74, 0x56,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x64, 0x65, 0x6c, 0x74, 0x61, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x64, 0x65, 0x6c, 0x74, 0x61, 0x12, 0x31, 0x0a, 0x0c,
+	0x72, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x5f, 0x72, 0x75, 0x6c, 0x65, 0x73, 0x18, 0x07, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x42, 0x6c, 0x61, 0x63, 0x6b, 0x6c, 0x69, 0x73, 0x74, 0x52, 0x75,
+	0x6c, 0x65, 0x52, 0x0b, 0x72, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x52, 0x75, 0x6c, 0x65, 0x73, 0x12,
+	0x29, 0x0a, 0x10, 0x72, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x5f, 0x62, 0x6c, 0x61, 0x63, 0x6b, 0x6c,
+	0x69, 0x73, 0x74, 0x18, 0x08, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0f, 0x72, 0x65, 0x6d, 0x6f, 0x76,
+	0x65, 0x42, 0x6c, 0x61, 0x63, 0x6b, 0x6c, 0x69, 0x73, 0x74, 0x32, 0xd3, 0x01, 0x0a, 0x04, 0x41,
 	0x75, 0x74, 0x68, 0x12, 0x26, 0x0a, 0x0b, 0x53, 0x65, 0x74, 0x55, 0x73, 0x65, 0x72, 0x44, 0x61,
 	0x74, 0x61, 0x12, 0x09, 0x2e, 0x41, 0x75, 0x74, 0x68, 0x49, 0x6e, 0x66, 0x6f, 0x1a, 0x0c, 0x2e,
 	0x4e, 0x75, 0x6c, 0x6c, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x29, 0x0a, 0x0e, 0x44,
@@ -349,34 +1015,47 @@ func file_protocol_grpc_proto_rawDescGZIP() []byte {
 	return file_protocol_grpc_proto_rawDescData
 }
 
-var file_protocol_grpc_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_protocol_grpc_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_protocol_grpc_proto_goTypes = []any{
-	(*NullMessage)(nil),        // 0: NullMessage
-	(*AuthInfo)(nil),           // 1: AuthInfo
-	(*DisconnectInfo)(nil),     // 2: DisconnectInfo
-	(*BlackListAccessLog)(nil), // 3: BlackListAccessLog
-	nil,                        // 4: AuthInfo.IpsEntry
+	(*NullMessage)(nil),         // 0: NullMessage
+	(*AuthInfo)(nil),            // 1: AuthInfo
+	(*AccessWindow)(nil),        // 2: AccessWindow
+	(*AuthToken)(nil),           // 3: AuthToken
+	(*DisconnectInfo)(nil),      // 4: DisconnectInfo
+	(*QuotaExhaustedEvent)(nil), // 5: QuotaExhaustedEvent
+	(*BlackListAccessLog)(nil),  // 6: BlackListAccessLog
+	(*BlacklistRule)(nil),       // 7: BlacklistRule
+	(*BlacklistRuleSet)(nil),    // 8: BlacklistRuleSet
+	nil,                         // 9: AuthInfo.IpsEntry
+	nil,                         // 10: AuthToken.IpsEntry
 }
 var file_protocol_grpc_proto_depIdxs = []int32{
-	4, // 0: AuthInfo.ips:type_name -> AuthInfo.IpsEntry
-	0, // 1: AuthInfo.IpsEntry.value:type_name -> NullMessage
-	1, // 2: Auth.SetUserData:input_type -> AuthInfo
-	1, // 3: Auth.DeleteUserData:input_type -> AuthInfo
-	1, // 4: Auth.GetUserData:input_type -> AuthInfo
-	1, // 5: Auth.AddUserData:input_type -> AuthInfo
-	2, // 6: Auth.Disconnect:input_type -> DisconnectInfo
-	1, // 7: RemoteAuth.GetUserData:input_type -> AuthInfo
-	0, // 8: Auth.SetUserData:output_type -> NullMessage
-	0, // 9: Auth.DeleteUserData:output_type -> NullMessage
-	1, // 10: Auth.GetUserData:output_type -> AuthInfo
-	0, // 11: Auth.AddUserData:output_type -> NullMessage
-	0, // 12: Auth.Disconnect:output_type -> NullMessage
-	1, // 13: RemoteAuth.GetUserData:output_type -> AuthInfo
-	8, // [8:14] is the sub-list for method output_type
-	2, // [2:8] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	9,  // 0: AuthInfo.ips:type_name -> AuthInfo.IpsEntry
+	2,  // 1: AuthInfo.access_schedule:type_name -> AccessWindow
+	7,  // 2: AuthInfo.allow_rules:type_name -> BlacklistRule
+	7,  // 3: AuthInfo.deny_rules:type_name -> BlacklistRule
+	10, // 4: AuthToken.ips:type_name -> AuthToken.IpsEntry
+	7,  // 5: BlacklistRuleSet.rules:type_name -> BlacklistRule
+	7,  // 6: BlacklistRuleSet.remove_rules:type_name -> BlacklistRule
+	0,  // 7: AuthInfo.IpsEntry.value:type_name -> NullMessage
+	0,  // 8: AuthToken.IpsEntry.value:type_name -> NullMessage
+	1,  // 9: Auth.SetUserData:input_type -> AuthInfo
+	1,  // 10: Auth.DeleteUserData:input_type -> AuthInfo
+	1,  // 11: Auth.GetUserData:input_type -> AuthInfo
+	1,  // 12: Auth.AddUserData:input_type -> AuthInfo
+	4,  // 13: Auth.Disconnect:input_type -> DisconnectInfo
+	1,  // 14: RemoteAuth.GetUserData:input_type -> AuthInfo
+	0,  // 15: Auth.SetUserData:output_type -> NullMessage
+	0,  // 16: Auth.DeleteUserData:output_type -> NullMessage
+	1,  // 17: Auth.GetUserData:output_type -> AuthInfo
+	0,  // 18: Auth.AddUserData:output_type -> NullMessage
+	0,  // 19: Auth.Disconnect:output_type -> NullMessage
+	1,  // 20: RemoteAuth.GetUserData:output_type -> AuthInfo
+	15, // [15:21] is the sub-list for method output_type
+	9,  // [9:15] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
 }
 
 func init() { file_protocol_grpc_proto_init() }
@@ -390,7 +1069,7 @@ func file_protocol_grpc_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_protocol_grpc_proto_rawDesc), len(file_protocol_grpc_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   11,
 			NumExtensions: 0,
 			NumServices:   2,
 		},
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap" // 高性能日志库
+
+	"proxy_server/common"
+	"proxy_server/log"
+	"proxy_server/protobuf"
+	"proxy_server/utils/rateLimit"
+)
+
+const USER_LIMIT_EVICT_OLDEST = "evict_oldest"
+
+// accountContext 账号级别的连接计数及整体限速
+// 下级账号的连接同时占用自身及上级账号的额度
+type accountContext struct {
+	a        *LimitedReaderAction       // 账号整体限速 未配置时为nil
+	c        int64                      // 当前连接数
+	newConn  *rateLimit.Bucket          // 新建连接速率 未配置时为nil
+	sessions map[uint64]*accountSession // 当前连接 用于超限时关闭最早的连接
+}
+
+// accountSession 占用账号额度的单个连接
+type accountSession struct {
+	start  time.Time
+	cancel context.CancelFunc
+}
+
+// accountConnection 单个连接占用的全部账号额度
+type accountConnection struct {
+	id       uint64 // 会话id
+	accounts []string
+	actions  []*LimitedReaderAction // 账号整体限速 读取时叠加使用
+}
+
+// accountLimit 账号的连接限制
+type accountLimit struct {
+	maxConn     int
+	newConnRate int
+	evictOldest bool
+}
+
+// addAccountConnection 为本次连接占用账号及上级账号的连接额度
+// cancel用于超限策略为evict_oldest时关闭该连接 连接结束后需调用deleteAccountConnection释放
+func (m *manager) addAccountConnection(ctx context.Context, authInfo *protobuf.AuthInfo, cancel context.CancelFunc) (*accountConnection, error) {
+	chain := []*protobuf.AuthInfo{authInfo}
+	if authInfo.Parent != "" {
+		parentInfo, err := m.loadParentAuthInfo(ctx, authInfo.Parent)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, parentInfo)
+	}
+
+	conf := m.getNacosConf().UserLimit
+	ac := &accountConnection{id: m.sessionCounter.Add(1)}
+	for i, info := range chain {
+		limit := accountLimit{
+			maxConn:     int(info.MaxConn),
+			newConnRate: int(info.NewConnRate),
+			evictOldest: conf.OverLimit == USER_LIMIT_EVICT_OLDEST,
+		}
+		// 全局配置只作用于连接的账号本身 上级账号按其自身数据限制
+		if i == 0 {
+			if limit.maxConn == 0 {
+				limit.maxConn = conf.MaxConn
+			}
+			if limit.newConnRate == 0 {
+				limit.newConnRate = conf.NewConnRate
+			}
+		}
+
+		accountCtx, err := m.addAccountConnectionCount(info.Username, info, limit, ac.id, cancel)
+		if err != nil {
+			m.deleteAccountConnection(ac)
+			return nil, err
+		}
+		ac.accounts = append(ac.accounts, info.Username)
+		if accountCtx.a != nil {
+			ac.actions = append(ac.actions, accountCtx.a)
+		}
+	}
+
+	return ac, nil
+}
+
+func (m *manager) addAccountConnectionCount(username string, info *protobuf.AuthInfo, limit accountLimit, id uint64, cancel context.CancelFunc) (accountCtx *accountContext, resErr error) {
+	m.accountCtxMap.Upsert(username, nil, func(exist bool, valueInMap *accountContext, newValue *accountContext) *accountContext {
+		if !exist {
+			valueInMap = &accountContext{sessions: map[uint64]*accountSession{}}
+		}
+		accountCtx = valueInMap
+
+		// 新建连接速率
+		if limit.newConnRate > 0 {
+			if valueInMap.newConn == nil {
+				valueInMap.newConn = rateLimit.NewBucket(limit.newConnRate, limit.newConnRate)
+			} else if valueInMap.newConn.Rate() != limit.newConnRate {
+				valueInMap.newConn.SetLimit(limit.newConnRate, limit.newConnRate)
+			}
+			if !valueInMap.newConn.AllowN(1) {
+				resErr = fmt.Errorf("%s账号新建连接速率达到上限 new_conn_rate:%d", username, limit.newConnRate)
+				return valueInMap
+			}
+		} else {
+			valueInMap.newConn = nil
+		}
+
+		// 并发连接数
+		if limit.maxConn > 0 && valueInMap.c >= int64(limit.maxConn) {
+			if !limit.evictOldest || !valueInMap.evictOldest() {
+				resErr = fmt.Errorf("%s账号连接数达到上限 max_conn:%d", username, limit.maxConn)
+				return valueInMap
+			}
+			log.Info("[account] 账号连接数达到上限 关闭最早的连接", zap.Any("username", username), zap.Any("max_conn", limit.maxConn))
+		}
+		valueInMap.c++
+		valueInMap.sessions[id] = &accountSession{start: time.Now(), cancel: cancel}
+
+		// 以最新的账号数据更新整体限速
+		if info.AccountReadRate > 0 {
+			if valueInMap.a == nil {
+				valueInMap.a = NewLimitedReaderAction(int(info.AccountReadRate), int(info.AccountReadBurst))
+			} else {
+				valueInMap.a.UpdateParameter(int(info.AccountReadRate), int(info.AccountReadBurst))
+			}
+		}
+		return valueInMap
+	})
+
+	return
+}
+
+// evictOldest 关闭最早的连接 被关闭的连接结束时才释放计数
+func (a *accountContext) evictOldest() bool {
+	var oldestId uint64
+	var oldest *accountSession
+	for id, session := range a.sessions {
+		if oldest == nil || session.start.Before(oldest.start) {
+			oldestId, oldest = id, session
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+
+	delete(a.sessions, oldestId)
+	oldest.cancel()
+	return true
+}
+
+func (m *manager) deleteAccountConnection(ac *accountConnection) {
+	for _, username := range ac.accounts {
+		m.accountCtxMap.RemoveCb(username, func(key string, valueInMap *accountContext, exists bool) bool {
+			if exists {
+				valueInMap.c--
+				delete(valueInMap.sessions, ac.id)
+				// 新建连接速率额度未恢复时保留 避免通过反复断开重建绕过限制
+				return valueInMap.c <= 0 && (valueInMap.newConn == nil || valueInMap.newConn.Full())
+			}
+			return false
+		})
+	}
+}
+
+// loadParentAuthInfo 读取上级账号数据 上级账号不存在或已失效时下级账号不可用
+func (m *manager) loadParentAuthInfo(ctx context.Context, parent string) (*protobuf.AuthInfo, error) {
+	strKey := fmt.Sprintf("%s_%s", REDIS_AUTH_USERDATA, parent)
+	strVal, err := common.GetRedisDB().Get(ctx, strKey).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("上级账号%s数据不存在", parent)
+		}
+		parentInfo, err := m.loadAuthInfoFromSnapshot(parent, "", err)
+		if err != nil {
+			return nil, err
+		}
+		return parentInfo, checkAuthInfoValidity(parentInfo, time.Now())
+	}
+
+	parentInfo := &protobuf.AuthInfo{}
+	if err := json.Unmarshal([]byte(strVal), parentInfo); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal解析上级账号%s数据%s失败 error:%+v", parent, strVal, err)
+	}
+	m.recordAuthSnapshot(parent, "", parentInfo)
+
+	if err := checkAuthInfoValidity(parentInfo, time.Now()); err != nil {
+		return nil, fmt.Errorf("上级账号已失效 %w", err)
+	}
+
+	return parentInfo, nil
+}
+
+// closeUserAndChildrenConnections 关闭账号及其全部下级账号的连接 ips为空时关闭所有出口ip的连接
+func (m *manager) closeUserAndChildrenConnections(username string, ips []string) {
+	ipSet := map[string]struct{}{}
+	for _, ip := range ips {
+		ipSet[ip] = struct{}{}
+	}
+
+	for v := range m.userCtxMap.Iter() {
+		keys := strings.SplitN(v.Key, ":", 2)
+		if len(keys) != 2 {
+			continue
+		}
+
+		// 判断是否为该账号或其下级账号
+		if keys[0] != username {
+			authInfo := v.Val.authInfo.Load()
+			if authInfo == nil || authInfo.Parent != username {
+				continue
+			}
+		}
+
+		if len(ipSet) > 0 {
+			if _, ok := ipSet[keys[1]]; !ok {
+				continue
+			}
+		}
+
+		connCtx := v.Val
+		removed := m.userCtxMap.RemoveCb(v.Key, func(key string, valueInMap *connContext, exists bool) bool {
+			return exists && valueInMap == connCtx
+		})
+		if removed {
+			connCtx.cancel()
+			log.Info("[account] 关闭账号连接", zap.Any("key", v.Key), zap.Any("username", username))
+		}
+	}
+}
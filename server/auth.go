@@ -4,13 +4,96 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"proxy_server/common"
 	"proxy_server/protobuf"
 )
 
-func (m *manager) Valid(ctx context.Context, username, password, ip string) (authInfo *protobuf.AuthInfo, resErr error) {
+func (m *manager) Valid(ctx context.Context, username, password, ip, clientIp string) (authInfo *protobuf.AuthInfo, resErr error) {
+	if strings.HasPrefix(password, TOKEN_PASSWORD_PRE) {
+		// 密码位置携带令牌
+		authInfo, resErr = m.ValidToken(ctx, strings.TrimPrefix(password, TOKEN_PASSWORD_PRE), ip, clientIp)
+		if resErr != nil {
+			return
+		}
+		if authInfo.Username != username {
+			resErr = fmt.Errorf("令牌不属于%s用户", username)
+			return nil, resErr
+		}
+		return
+	}
+
+	authInfo, resErr = m.loadAuthInfo(ctx, username, ip)
+	if resErr != nil {
+		return
+	}
+
+	if authInfo.Username != username || authInfo.Password != password {
+		resErr = fmt.Errorf("%s用户密码错误 用户数据%+v", username, authInfo)
+		return nil, resErr
+	}
+
+	if resErr = m.checkAuthInfo(authInfo, clientIp); resErr != nil {
+		return nil, resErr
+	}
+
+	return authInfo, nil
+}
+
+// ValidToken 令牌鉴权 校验令牌有效期及出口ip范围后加载令牌所属账号
+func (m *manager) ValidToken(ctx context.Context, token, ip, clientIp string) (authInfo *protobuf.AuthInfo, resErr error) {
+	if token == "" {
+		resErr = fmt.Errorf("令牌为空")
+		return
+	}
+
+	tokenKey := fmt.Sprintf("%s_%s", REDIS_AUTH_TOKEN, token)
+	strVal, err := common.GetRedisDB().Get(ctx, tokenKey).Result()
+	if err != nil {
+		if err == redis.Nil {
+			resErr = fmt.Errorf("令牌不存在")
+			return
+		}
+		resErr = fmt.Errorf("获取令牌数据失败 error:%+v", err)
+		return
+	}
+
+	authToken := &protobuf.AuthToken{}
+	if err := json.Unmarshal([]byte(strVal), authToken); err != nil {
+		resErr = fmt.Errorf("json.Unmarshal解析令牌数据失败 error:%+v", err)
+		return
+	}
+
+	if authToken.ExpireUnix != 0 && time.Now().Unix() >= authToken.ExpireUnix {
+		resErr = fmt.Errorf("%s用户令牌已过期 expire_unix:%d", authToken.Username, authToken.ExpireUnix)
+		return
+	}
+
+	if len(authToken.Ips) > 0 {
+		if _, ok := authToken.Ips[ip]; !ok {
+			resErr = fmt.Errorf("%s用户令牌不允许使用出口ip:%s", authToken.Username, ip)
+			return
+		}
+	}
+
+	authInfo, resErr = m.loadAuthInfo(ctx, authToken.Username, ip)
+	if resErr != nil {
+		return
+	}
+
+	if resErr = m.checkAuthInfo(authInfo, clientIp); resErr != nil {
+		return nil, resErr
+	}
+
+	return authInfo, nil
+}
+
+// loadAuthInfo 从redis读取账号数据 并检测出口ip是否属于该账号
+func (m *manager) loadAuthInfo(ctx context.Context, username, ip string) (authInfo *protobuf.AuthInfo, resErr error) {
 	// 创建管道
 	pipe := common.GetRedisDB().Pipeline()
 
@@ -22,11 +105,10 @@ func (m *manager) Valid(ctx context.Context, username, password, ip string) (aut
 	getOp := pipe.Get(ctx, strKey)
 	sismemberOp := pipe.SIsMember(ctx, setKey, ip)
 
-	// 执行管道操作
+	// 执行管道操作 用户数据不存在时返回redis.Nil 不属于redis故障
 	_, err := pipe.Exec(ctx)
-	if err != nil {
-		resErr = fmt.Errorf("redis管道命令执行失败 error:%+v", err)
-		return
+	if err != nil && err != redis.Nil {
+		return m.loadAuthInfoFromSnapshot(username, ip, err)
 	}
 
 	// 处理获取字符串值的结果
@@ -45,25 +127,61 @@ func (m *manager) Valid(ctx context.Context, username, password, ip string) (aut
 	err = json.Unmarshal([]byte(strVal), authInfo)
 	if err != nil {
 		resErr = fmt.Errorf("json.Unmarshal解析%s用户数据%s失败 error:%+v", username, strVal, err)
-		return
-	}
-
-	if authInfo.Username != username || authInfo.Password != password {
-		resErr = fmt.Errorf("%s用户密码错误 用户数据%+v", username, authInfo)
-		return
+		return nil, resErr
 	}
 
 	// 处理判断集合元素是否存在的结果
 	exists, err := sismemberOp.Result()
 	if err != nil {
 		resErr = fmt.Errorf("检测%s用户ip:%+v 执行命令失败 error:%+v", username, ip, err)
-		return
+		return nil, resErr
 	}
 
 	if !exists {
 		resErr = fmt.Errorf("检测%s用户ip:%+v不存在", username, ip)
-		return
+		return nil, resErr
 	}
 
+	m.recordAuthSnapshot(username, ip, authInfo)
 	return authInfo, nil
 }
+
+// checkAuthInfo 校验账号有效期及客户端来源
+func (m *manager) checkAuthInfo(authInfo *protobuf.AuthInfo, clientIp string) error {
+	if err := checkAuthInfoValidity(authInfo, time.Now()); err != nil {
+		return err
+	}
+
+	return checkClientIp(authInfo, clientIp)
+}
+
+// checkClientIp 校验客户端来源ip是否在账号允许的网段内
+func checkClientIp(authInfo *protobuf.AuthInfo, clientIp string) error {
+	if len(authInfo.AllowClientCidrs) == 0 {
+		return nil
+	}
+
+	ip := net.ParseIP(clientIp)
+	if ip == nil {
+		return fmt.Errorf("%s用户客户端ip:%s 解析失败", authInfo.Username, clientIp)
+	}
+
+	for _, v := range authInfo.AllowClientCidrs {
+		if !strings.Contains(v, "/") {
+			if allowIp := net.ParseIP(v); allowIp != nil && allowIp.Equal(ip) {
+				return nil
+			}
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			continue
+		}
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s用户客户端ip:%s 不在允许的网段内", authInfo.Username, clientIp)
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap" // 高性能日志库
+
+	"proxy_server/config"
+	"proxy_server/log"
+	"proxy_server/protobuf"
+)
+
+const (
+	AuthSnapshotFileName        = "auth_snapshot.json"
+	AuthSnapshotDefaultMaxStale = 3600 // 默认快照最长可用时间（秒）
+)
+
+// authSnapshotEntry 最近一次鉴权成功的账号数据
+// redis不可用时只读使用，不会被降级鉴权刷新
+type authSnapshotEntry struct {
+	Info *protobuf.AuthInfo `json:"info"`
+	Ips  map[string]int64   `json:"ips"` // 出口ip -> 最近一次在redis中确认属于该账号的时间
+	Ts   int64              `json:"ts"`  // 账号数据最近一次从redis读取成功的时间
+}
+
+func authSnapshotPath() string {
+	return filepath.Join(config.GetConf().GetDataDir(), AuthSnapshotFileName)
+}
+
+// authSnapshotMaxStale 返回快照可用时长 小于等于0表示关闭降级鉴权
+func (m *manager) authSnapshotMaxStale() int64 {
+	maxStale := m.getNacosConf().AuthSnapshot.MaxStaleSeconds
+	if maxStale == 0 {
+		return AuthSnapshotDefaultMaxStale
+	}
+	return maxStale
+}
+
+// recordAuthSnapshot 记录一次redis鉴权成功的结果
+func (m *manager) recordAuthSnapshot(username, ip string, authInfo *protobuf.AuthInfo) {
+	now := time.Now().Unix()
+	m.authSnapshot.Upsert(username, nil, func(exist bool, valueInMap *authSnapshotEntry, newValue *authSnapshotEntry) *authSnapshotEntry {
+		entry := &authSnapshotEntry{Info: authInfo, Ips: map[string]int64{}, Ts: now}
+		if exist {
+			for k, v := range valueInMap.Ips {
+				entry.Ips[k] = v
+			}
+		}
+		if ip != "" {
+			entry.Ips[ip] = now
+		}
+		return entry
+	})
+	m.authSnapshotDirty.Store(true)
+	m.setAuthDegraded(false, nil)
+}
+
+// replaceAuthSnapshot 账号数据变更后覆盖快照 出口ip以本次写入redis的为准
+// 避免redis不可用时旧密码或已移除的ip仍能通过降级鉴权
+func (m *manager) replaceAuthSnapshot(authInfo *protobuf.AuthInfo, ips []string) {
+	now := time.Now().Unix()
+	entry := &authSnapshotEntry{Info: authInfo, Ips: make(map[string]int64, len(ips)), Ts: now}
+	for _, ip := range ips {
+		entry.Ips[ip] = now
+	}
+	m.authSnapshot.Set(authInfo.Username, entry)
+	m.authSnapshotDirty.Store(true)
+}
+
+// removeAuthSnapshot 账号删除后移除快照
+func (m *manager) removeAuthSnapshot(username string) {
+	m.authSnapshot.Remove(username)
+	m.authSnapshotDirty.Store(true)
+}
+
+// loadAuthInfoFromSnapshot redis不可用时从快照读取账号数据
+func (m *manager) loadAuthInfoFromSnapshot(username, ip string, redisErr error) (*protobuf.AuthInfo, error) {
+	m.setAuthDegraded(true, redisErr)
+
+	maxStale := m.authSnapshotMaxStale()
+	if maxStale < 0 {
+		return nil, fmt.Errorf("redis管道命令执行失败 降级鉴权已关闭 error:%+v", redisErr)
+	}
+
+	entry, ok := m.authSnapshot.Get(username)
+	if !ok {
+		return nil, fmt.Errorf("redis管道命令执行失败 快照中不存在%s用户 error:%+v", username, redisErr)
+	}
+
+	now := time.Now().Unix()
+	if now-entry.Ts > maxStale {
+		return nil, fmt.Errorf("redis管道命令执行失败 %s用户快照已过期 ts:%d error:%+v", username, entry.Ts, redisErr)
+	}
+
+	// ip为空时只读取账号数据 用于上级账号
+	if ip != "" {
+		ipTs, ok := entry.Ips[ip]
+		if !ok || now-ipTs > maxStale {
+			return nil, fmt.Errorf("redis管道命令执行失败 快照中检测%s用户ip:%+v不存在 error:%+v", username, ip, redisErr)
+		}
+	}
+
+	m.authDegradedHits.Add(1)
+	return entry.Info, nil
+}
+
+// setAuthDegraded 切换降级状态 状态变化时记录日志
+func (m *manager) setAuthDegraded(degraded bool, err error) {
+	if m.authDegraded.Swap(degraded) == degraded {
+		return
+	}
+	if degraded {
+		log.Error("[auth_snapshot] redis不可用 进入降级鉴权模式", zap.Error(err))
+	} else {
+		log.Info("[auth_snapshot] redis恢复 退出降级鉴权模式", zap.Any("降级鉴权次数", m.authDegradedHits.Load()))
+	}
+}
+
+// loadAuthSnapshot 启动时从本地文件加载快照
+func (m *manager) loadAuthSnapshot() {
+	data, err := os.ReadFile(authSnapshotPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("[auth_snapshot] 读取鉴权快照失败", zap.Error(err))
+		}
+		return
+	}
+
+	entries := map[string]*authSnapshotEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Error("[auth_snapshot] 解析鉴权快照失败", zap.Error(err))
+		return
+	}
+
+	for k, v := range entries {
+		if v == nil || v.Info == nil {
+			continue
+		}
+		m.authSnapshot.Set(k, v)
+	}
+	log.Info("[auth_snapshot] 加载鉴权快照", zap.Any("count", len(entries)))
+}
+
+// saveAuthSnapshot 将快照写入本地文件 先写临时文件再重命名 避免写入中途崩溃损坏快照
+func (m *manager) saveAuthSnapshot() error {
+	maxStale := m.authSnapshotMaxStale()
+	now := time.Now().Unix()
+
+	entries := map[string]*authSnapshotEntry{}
+	for v := range m.authSnapshot.Iter() {
+		if maxStale > 0 && now-v.Val.Ts > maxStale {
+			m.authSnapshot.RemoveCb(v.Key, func(key string, valueInMap *authSnapshotEntry, exists bool) bool {
+				return exists && valueInMap == v.Val
+			})
+			continue
+		}
+		entries[v.Key] = v.Val
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("序列化鉴权快照失败 %w", err)
+	}
+
+	path := authSnapshotPath()
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("写入鉴权快照失败 %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("重命名鉴权快照失败 %w", err)
+	}
+	return nil
+}
+
+// runAuthSnapshotPersist 定时持久化快照 降级期间持续输出告警日志
+func (m *manager) runAuthSnapshotPersist(ctx context.Context) {
+	loopTime := 30 * time.Second
+	ticker := time.NewTicker(loopTime)
+	defer ticker.Stop()
+
+	for {
+		ticker.Reset(loopTime)
+		select {
+		case <-ctx.Done():
+			if m.authSnapshotDirty.Swap(false) {
+				if err := m.saveAuthSnapshot(); err != nil {
+					log.Error("[auth_snapshot] 保存鉴权快照失败", zap.Error(err))
+				}
+			}
+			return
+		case <-ticker.C:
+			if m.authDegraded.Load() {
+				log.Error("[auth_snapshot] 当前处于降级鉴权模式", zap.Any("降级鉴权次数", m.authDegradedHits.Load()))
+			}
+			if m.authSnapshotDirty.Swap(false) {
+				if err := m.saveAuthSnapshot(); err != nil {
+					log.Error("[auth_snapshot] 保存鉴权快照失败", zap.Error(err))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"proxy_server/protobuf"
+	"proxy_server/utils/blacklistRule"
+)
+
+const (
+	BLACKLIST_RULE_VERSION = 3                        // 当前支持的黑名单格式版本
+	PROTOBUF_CONTENT_TYPE  = "application/x-protobuf" // 消息体为protobuf时的content_type
+)
+
+// compileBlacklist 编译黑名单广播消息 旧版域名列表按suffix规则处理
+// 观察模式的规则单独编译 命中时只上报不拦截
+func compileBlacklist(set *protobuf.BlacklistRuleSet) (matcher, monitor *blacklistRule.Matcher, err error) {
+	if set.Version > BLACKLIST_RULE_VERSION {
+		return nil, nil, fmt.Errorf("黑名单格式版本%d不支持 当前版本%d", set.Version, BLACKLIST_RULE_VERSION)
+	}
+
+	rules := make([]blacklistRule.Rule, 0, len(set.Rules)+len(set.Blacklist))
+	for _, v := range set.Blacklist {
+		rules = append(rules, blacklistRule.Rule{Id: v, Type: blacklistRule.RULE_SUFFIX, Value: v})
+	}
+	enforced := make([]*protobuf.BlacklistRule, 0, len(set.Rules))
+	monitored := []*protobuf.BlacklistRule{}
+	for _, v := range set.Rules {
+		if v.Monitor {
+			monitored = append(monitored, v)
+		} else {
+			enforced = append(enforced, v)
+		}
+	}
+	rules = append(rules, convertBlacklistRules(enforced)...)
+
+	if matcher, err = blacklistRule.Compile(rules); err != nil {
+		return nil, nil, err
+	}
+	if monitor, err = blacklistRule.Compile(convertBlacklistRules(monitored)); err != nil {
+		return nil, nil, err
+	}
+	return matcher, monitor, nil
+}
+
+func convertBlacklistRules(list []*protobuf.BlacklistRule) []blacklistRule.Rule {
+	rules := make([]blacklistRule.Rule, 0, len(list))
+	for _, v := range list {
+		rule := blacklistRule.Rule{
+			Id:       v.Id,
+			Type:     v.Type,
+			Value:    v.Value,
+			Protocol: v.Protocol,
+		}
+		for _, port := range v.Ports {
+			rule.Ports = append(rule.Ports, int(port))
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// SetBlacklist 更新黑名单
+func (m *manager) SetBlacklist(matcher *blacklistRule.Matcher) {
+	m.blacklist.Store(matcher)
+}
+
+// blacklistTarget 组合待检测的目标 域名为空时按地址中的ip检测
+func blacklistTarget(domain, address, protocol string) blacklistRule.Target {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	port, _ := strconv.Atoi(portStr)
+	if domain != "" {
+		host = domain
+	}
+	return blacklistRule.Target{Host: host, Port: port, Protocol: protocol}
+}
+
+// matchBlacklist 检测目标是否命中黑名单 返回命中的规则标识
+func (m *manager) matchBlacklist(target blacklistRule.Target) (string, bool) {
+	rule, ok := m.blacklist.Load().Match(target)
+	if !ok {
+		return "", false
+	}
+	return rule.String(), true
+}
+
+// matchBlacklistMonitor 检测目标是否命中观察模式的规则
+func (m *manager) matchBlacklistMonitor(target blacklistRule.Target) (string, bool) {
+	rule, ok := m.blacklistMonitor.Load().Match(target)
+	if !ok {
+		return "", false
+	}
+	return rule.String(), true
+}
+
+// countBlacklistMonitor 累计观察模式规则的命中次数
+func (m *manager) countBlacklistMonitor(rule string) {
+	m.monitorHitMap.Upsert(rule, nil, func(exist bool, valueInMap *atomic.Uint64, newValue *atomic.Uint64) *atomic.Uint64 {
+		if !exist {
+			valueInMap = &atomic.Uint64{}
+		}
+		valueInMap.Add(1)
+		return valueInMap
+	})
+}
+
+// pruneBlacklistMonitor 移除已不在观察模式的规则的命中次数 规则转为生效或被删除后不再展示
+func (m *manager) pruneBlacklistMonitor(set *protobuf.BlacklistRuleSet) {
+	ids := map[string]bool{}
+	for _, v := range set.Rules {
+		if v.Monitor {
+			rule := convertBlacklistRules([]*protobuf.BlacklistRule{v})[0]
+			ids[rule.String()] = true
+		}
+	}
+	for v := range m.monitorHitMap.Iter() {
+		if !ids[v.Key] {
+			m.monitorHitMap.Remove(v.Key)
+		}
+	}
+}
+
+func (m *manager) blacklistMonitorStats() map[string]uint64 {
+	stats := map[string]uint64{}
+	for v := range m.monitorHitMap.Iter() {
+		stats[v.Key] = v.Val.Load()
+	}
+	return stats
+}
+
+func derefString(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
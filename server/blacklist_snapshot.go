@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap" // 高性能日志库
+
+	"proxy_server/config"
+	"proxy_server/log"
+	"proxy_server/protobuf"
+)
+
+const BlacklistSnapshotFileName = "blacklist_snapshot.json"
+
+func blacklistSnapshotPath() string {
+	return filepath.Join(config.GetConf().GetDataDir(), BlacklistSnapshotFileName)
+}
+
+// saveBlacklistSnapshot 将生效的黑名单写入本地文件 先写临时文件再重命名
+func saveBlacklistSnapshot(state *blacklistState) error {
+	data, err := json.Marshal(state.ruleSet())
+	if err != nil {
+		return fmt.Errorf("序列化黑名单快照失败 %w", err)
+	}
+
+	path := blacklistSnapshotPath()
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("写入黑名单快照失败 %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("重命名黑名单快照失败 %w", err)
+	}
+	return nil
+}
+
+// loadBlacklist 启动时加载本地黑名单快照 开启时再从redis读取更新的全量快照
+// 需在监听端口前调用
+func (m *manager) loadBlacklist() {
+	if data, err := os.ReadFile(blacklistSnapshotPath()); err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("[blacklist] 读取黑名单快照失败", zap.Error(err))
+		}
+	} else {
+		set := &protobuf.BlacklistRuleSet{}
+		if err := json.Unmarshal(data, set); err != nil {
+			log.Error("[blacklist] 解析黑名单快照失败", zap.Error(err))
+		} else if err := m.applyBlacklist(context.Background(), set); err != nil {
+			log.Error("[blacklist] 加载黑名单快照失败", zap.Error(err))
+		}
+	}
+
+	if m.getNacosConf().Blacklist.BootstrapRedis {
+		if set, err := m.fetchBlacklistSnapshot(context.Background()); err != nil {
+			log.Error("[blacklist] 从redis读取黑名单失败", zap.Error(err))
+		} else if err := m.applyBlacklist(context.Background(), set); err != nil {
+			log.Info("[blacklist] 未使用redis中的黑名单", zap.Error(err))
+		}
+	}
+
+	if m.blacklist.Load() == nil {
+		log.Error("[blacklist] 没有可用的黑名单 等待黑名单广播", zap.Any("strict", m.getNacosConf().Blacklist.Strict))
+	}
+}
+
+// blacklistUnavailable 严格模式下黑名单未加载时拒绝所有连接
+func (m *manager) blacklistUnavailable() bool {
+	return m.getNacosConf().Blacklist.Strict && m.blacklist.Load() == nil
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap" // 高性能日志库
+	"google.golang.org/protobuf/proto"
+
+	"proxy_server/common"
+	"proxy_server/log"
+	"proxy_server/protobuf"
+	"proxy_server/utils/blacklistRule"
+)
+
+// blacklistState 当前生效的黑名单 用于按版本应用增量
+type blacklistState struct {
+	listVersion int64
+	ts          int64
+	rules       map[string]*protobuf.BlacklistRule // 规则键 -> 规则
+}
+
+// blacklistRuleKey 有id时使用id 否则使用规则内容
+func blacklistRuleKey(rule *protobuf.BlacklistRule) string {
+	if rule.Id != "" {
+		return rule.Id
+	}
+	ports := make([]string, 0, len(rule.Ports))
+	for _, port := range rule.Ports {
+		ports = append(ports, fmt.Sprint(port))
+	}
+	return fmt.Sprintf("%s:%s:%s:%s", rule.Type, rule.Value, strings.Join(ports, ","), rule.Protocol)
+}
+
+// legacyBlacklistRule 旧版域名按suffix规则处理
+func legacyBlacklistRule(domain string) *protobuf.BlacklistRule {
+	return &protobuf.BlacklistRule{Id: domain, Type: blacklistRule.RULE_SUFFIX, Value: domain}
+}
+
+func newBlacklistState(set *protobuf.BlacklistRuleSet) *blacklistState {
+	s := &blacklistState{
+		listVersion: set.ListVersion,
+		ts:          set.Ts,
+		rules:       map[string]*protobuf.BlacklistRule{},
+	}
+	s.add(set.Rules, set.Blacklist)
+	return s
+}
+
+func (s *blacklistState) add(rules []*protobuf.BlacklistRule, domains []string) {
+	for _, v := range domains {
+		rule := legacyBlacklistRule(v)
+		s.rules[blacklistRuleKey(rule)] = rule
+	}
+	for _, rule := range rules {
+		s.rules[blacklistRuleKey(rule)] = rule
+	}
+}
+
+// applyDelta 在副本上应用增量 先删除后新增
+func (s *blacklistState) applyDelta(set *protobuf.BlacklistRuleSet) *blacklistState {
+	next := &blacklistState{
+		listVersion: set.ListVersion,
+		ts:          set.Ts,
+		rules:       make(map[string]*protobuf.BlacklistRule, len(s.rules)),
+	}
+	for k, v := range s.rules {
+		next.rules[k] = v
+	}
+	for _, v := range set.RemoveBlacklist {
+		delete(next.rules, blacklistRuleKey(legacyBlacklistRule(v)))
+	}
+	for _, rule := range set.RemoveRules {
+		delete(next.rules, blacklistRuleKey(rule))
+	}
+	next.add(set.Rules, set.Blacklist)
+	return next
+}
+
+// ruleSet 转换为全量规则 按规则键排序保证每次编译结果一致
+func (s *blacklistState) ruleSet() *protobuf.BlacklistRuleSet {
+	keys := make([]string, 0, len(s.rules))
+	for k := range s.rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	set := &protobuf.BlacklistRuleSet{
+		Version:     BLACKLIST_RULE_VERSION,
+		Ts:          s.ts,
+		ListVersion: s.listVersion,
+		Rules:       make([]*protobuf.BlacklistRule, 0, len(keys)),
+	}
+	for _, k := range keys {
+		set.Rules = append(set.Rules, s.rules[k])
+	}
+	return set
+}
+
+// applyBlacklist 应用全量或增量黑名单 过期的消息直接丢弃
+// 增量版本不连续时从redis读取全量快照 无法补齐时保留当前黑名单
+func (m *manager) applyBlacklist(ctx context.Context, set *protobuf.BlacklistRuleSet) error {
+	if set.Version > BLACKLIST_RULE_VERSION {
+		return fmt.Errorf("黑名单格式版本%d不支持 当前版本%d", set.Version, BLACKLIST_RULE_VERSION)
+	}
+
+	m.blacklistMu.Lock()
+	defer m.blacklistMu.Unlock()
+
+	cur := m.blacklistState
+	if !set.Delta {
+		if cur != nil && isStaleBlacklist(cur, set) {
+			return fmt.Errorf("黑名单全量版本已过期 list_version:%d ts:%d 当前list_version:%d ts:%d", set.ListVersion, set.Ts, cur.listVersion, cur.ts)
+		}
+		return m.setBlacklistState(newBlacklistState(set))
+	}
+
+	if cur != nil && set.ListVersion <= cur.listVersion {
+		return fmt.Errorf("黑名单增量版本已过期 list_version:%d 当前list_version:%d", set.ListVersion, cur.listVersion)
+	}
+
+	if cur == nil || set.ListVersion != cur.listVersion+1 {
+		var curVersion int64
+		if cur != nil {
+			curVersion = cur.listVersion
+		}
+		log.Error("[blacklist] 黑名单增量版本不连续 读取全量快照", zap.Any("list_version", set.ListVersion), zap.Any("current", curVersion))
+
+		snapshot, err := m.fetchBlacklistSnapshot(ctx)
+		if err != nil {
+			return fmt.Errorf("黑名单增量版本不连续 读取全量快照失败 error:%+v", err)
+		}
+		if cur != nil && snapshot.ListVersion <= cur.listVersion {
+			return fmt.Errorf("黑名单增量版本不连续 全量快照版本%d未更新", snapshot.ListVersion)
+		}
+		if err := m.setBlacklistState(newBlacklistState(snapshot)); err != nil {
+			return err
+		}
+		cur = m.blacklistState
+		// 快照已包含本次增量
+		if set.ListVersion <= cur.listVersion {
+			return nil
+		}
+		if set.ListVersion != cur.listVersion+1 {
+			return fmt.Errorf("黑名单增量版本%d与全量快照版本%d不连续", set.ListVersion, cur.listVersion)
+		}
+	}
+
+	return m.setBlacklistState(cur.applyDelta(set))
+}
+
+// isStaleBlacklist 有名单版本时按版本判断 否则按ts判断
+func isStaleBlacklist(cur *blacklistState, set *protobuf.BlacklistRuleSet) bool {
+	if set.ListVersion > 0 || cur.listVersion > 0 {
+		return set.ListVersion <= cur.listVersion
+	}
+	return set.Ts < cur.ts
+}
+
+// setBlacklistState 编译并生效 编译失败时保留当前黑名单 需持有blacklistMu
+func (m *manager) setBlacklistState(state *blacklistState) error {
+	set := state.ruleSet()
+	matcher, monitor, err := compileBlacklist(set)
+	if err != nil {
+		return err
+	}
+	m.blacklistState = state
+	m.SetBlacklist(matcher)
+	m.blacklistMonitor.Store(monitor)
+	m.pruneBlacklistMonitor(set)
+	m.enforceBlacklist()
+	log.Info("[blacklist] 黑名单更新成功", zap.Any("list_version", state.listVersion), zap.Any("rules", matcher.Len()), zap.Any("monitor_rules", monitor.Len()), zap.Any("ts", state.ts))
+
+	if err := saveBlacklistSnapshot(state); err != nil {
+		log.Error("[blacklist] 保存黑名单快照失败", zap.Error(err))
+	}
+	return nil
+}
+
+// fetchBlacklistSnapshot 从redis读取全量黑名单 支持json及protobuf
+func (m *manager) fetchBlacklistSnapshot(ctx context.Context) (*protobuf.BlacklistRuleSet, error) {
+	val, err := common.GetRedisDB().Get(ctx, REDIS_BLACKLIST).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, fmt.Errorf("redis中不存在黑名单全量快照%s", REDIS_BLACKLIST)
+		}
+		return nil, err
+	}
+
+	set := &protobuf.BlacklistRuleSet{}
+	if len(val) > 0 && val[0] == '{' {
+		err = json.Unmarshal(val, set)
+	} else {
+		err = proto.Unmarshal(val, set)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析黑名单全量快照失败 error:%+v", err)
+	}
+	if set.Delta {
+		return nil, fmt.Errorf("黑名单全量快照不能是增量")
+	}
+	return set, nil
+}
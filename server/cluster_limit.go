@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap" // 高性能日志库
+
+	"proxy_server/common"
+	"proxy_server/config"
+	"proxy_server/log"
+	"proxy_server/server/lua"
+)
+
+const (
+	ClusterLeaseDefaultSeconds  = 15  // 默认租约时长（秒）
+	ClusterFastPathDefaultRatio = 0.5 // 默认本地快速通过比例
+)
+
+// clusterCounter 本节点在某个集群计数键上的连接数
+// 心跳遍历时不持有分片锁 字段使用原子操作
+type clusterCounter struct {
+	c      atomic.Int64 // 本节点连接数
+	others atomic.Int64 // 最近一次从redis得到的其他节点连接数
+	nodes  atomic.Int64 // 最近一次从redis得到的持有租约的节点数 包含本节点 0表示尚未同步
+}
+
+// clusterConnection 单个连接占用的集群计数键
+type clusterConnection struct {
+	keys []string
+}
+
+func clusterNodeId() string {
+	return fmt.Sprintf("%s_%s", config.GetConf().LocalIp, config.GetConf().ProcessName)
+}
+
+func (m *manager) clusterLease() int64 {
+	lease := m.getNacosConf().ClusterLimit.LeaseSeconds
+	if lease <= 0 {
+		return ClusterLeaseDefaultSeconds
+	}
+	return lease
+}
+
+// clusterFastPath 判断是否可以只在本地计数 不访问redis
+// 键至少同步过一次后 已知的集群连接数低于上限的一定比例 且本节点连接数不超过按节点数均分的份额
+// 其他节点的连接数最长延迟1/3租约时长 均分份额保证各节点同时走快速路径时合计不超过该比例
+func (m *manager) clusterFastPath(limit int, local, others, nodes int64) bool {
+	if nodes <= 0 {
+		return false
+	}
+	ratio := m.getNacosConf().ClusterLimit.FastPathRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = ClusterFastPathDefaultRatio
+	}
+	fast := float64(limit) * ratio
+	return float64(local+others) <= fast && float64(local) <= fast/float64(nodes)
+}
+
+// addClusterConnection 占用用户及出口ip的集群连接额度 未开启时直接返回
+// 连接结束后需调用deleteClusterConnection释放
+func (m *manager) addClusterConnection(ctx context.Context, username, ip string) (*clusterConnection, error) {
+	cc := &clusterConnection{}
+	conf := m.getNacosConf().ClusterLimit
+	if !conf.Enable {
+		return cc, nil
+	}
+
+	items := []struct {
+		key   string
+		limit int
+	}{
+		{fmt.Sprintf("%s_user_%s", REDIS_CLUSTER_CONN, username), conf.UserMaxConn},
+		{fmt.Sprintf("%s_ip_%s", REDIS_CLUSTER_CONN, ip), conf.IpMaxConn},
+	}
+	for _, item := range items {
+		if item.limit <= 0 {
+			continue
+		}
+		if err := m.acquireClusterConn(ctx, item.key, item.limit); err != nil {
+			m.deleteClusterConnection(cc)
+			return nil, err
+		}
+		cc.keys = append(cc.keys, item.key)
+	}
+	return cc, nil
+}
+
+func (m *manager) acquireClusterConn(ctx context.Context, key string, limit int) error {
+	var local, others, nodes int64
+	m.clusterConnMap.Upsert(key, nil, func(exist bool, valueInMap *clusterCounter, newValue *clusterCounter) *clusterCounter {
+		if !exist {
+			valueInMap = &clusterCounter{}
+		}
+		local, others, nodes = valueInMap.c.Add(1), valueInMap.others.Load(), valueInMap.nodes.Load()
+		return valueInMap
+	})
+
+	// 远低于上限时只在本地计数 由心跳同步到redis
+	if m.clusterFastPath(limit, local, others, nodes) {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	res, err := common.GetRedisDB().EvalSha(ctx, lua.ClusterConnLuaScriptShaCode, []string{key}, clusterNodeId(), local, limit, now, m.clusterLease()).Int64Slice()
+	if err != nil || len(res) != 3 {
+		// redis故障时退化为单节点限制
+		log.Error("[cluster_limit] 执行lua脚本失败", zap.Error(err), zap.Any("key", key))
+		return nil
+	}
+	m.setClusterOthers(key, res[1], res[2])
+
+	if res[0] != 1 {
+		m.releaseClusterConn(key)
+		return fmt.Errorf("%s集群连接数达到上限 limit:%d others:%d", key, limit, res[1])
+	}
+	return nil
+}
+
+func (m *manager) setClusterOthers(key string, others, nodes int64) {
+	m.clusterConnMap.Upsert(key, nil, func(exist bool, valueInMap *clusterCounter, newValue *clusterCounter) *clusterCounter {
+		if !exist {
+			valueInMap = &clusterCounter{}
+		}
+		valueInMap.others.Store(others)
+		valueInMap.nodes.Store(nodes)
+		return valueInMap
+	})
+}
+
+// releaseClusterConn 释放本节点计数 计数为0的键由心跳从redis删除后移除
+func (m *manager) releaseClusterConn(key string) {
+	m.clusterConnMap.Upsert(key, nil, func(exist bool, valueInMap *clusterCounter, newValue *clusterCounter) *clusterCounter {
+		if !exist {
+			valueInMap = &clusterCounter{}
+		}
+		if valueInMap.c.Load() > 0 {
+			valueInMap.c.Add(-1)
+		}
+		return valueInMap
+	})
+}
+
+func (m *manager) deleteClusterConnection(cc *clusterConnection) {
+	for _, key := range cc.keys {
+		m.releaseClusterConn(key)
+	}
+}
+
+// runClusterHeartbeat 定时续约本节点的连接数 并刷新其他节点的连接数
+// 节点崩溃后其租约到期 连接数不再计入
+func (m *manager) runClusterHeartbeat(ctx context.Context) {
+	loopTime := time.Duration(m.clusterLease()) * time.Second / 3
+	ticker := time.NewTicker(loopTime)
+	defer ticker.Stop()
+
+	for {
+		loopTime = time.Duration(m.clusterLease()) * time.Second / 3
+		ticker.Reset(loopTime)
+		select {
+		case <-ctx.Done():
+			// 退出前删除本节点的计数
+			m.clearClusterConn(context.Background())
+			return
+		case <-ticker.C:
+			m.syncClusterConn(ctx)
+		}
+	}
+}
+
+func (m *manager) syncClusterConn(ctx context.Context) {
+	node := clusterNodeId()
+	now := time.Now().Unix()
+	lease := m.clusterLease()
+
+	keys := []string{}
+	counts := []int64{}
+	pipe := common.GetRedisDB().Pipeline()
+	for v := range m.clusterConnMap.Iter() {
+		c := v.Val.c.Load()
+		if c > 0 {
+			pipe.HSet(ctx, v.Key, node, fmt.Sprintf("%d:%d", c, now+lease))
+			pipe.Expire(ctx, v.Key, time.Duration(lease*2)*time.Second)
+		} else {
+			pipe.HDel(ctx, v.Key, node)
+		}
+		keys = append(keys, v.Key)
+		counts = append(counts, c)
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	allOps := make([]*redis.MapStringStringCmd, len(keys))
+	for i, key := range keys {
+		allOps[i] = pipe.HGetAll(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Error("[cluster_limit] 同步集群连接数失败", zap.Error(err), zap.Any("count", len(keys)))
+		return
+	}
+
+	for i, key := range keys {
+		if counts[i] == 0 {
+			m.clusterConnMap.RemoveCb(key, func(key string, valueInMap *clusterCounter, exists bool) bool {
+				return exists && valueInMap.c.Load() == 0
+			})
+			continue
+		}
+
+		fields, err := allOps[i].Result()
+		if err != nil {
+			continue
+		}
+		var others int64
+		nodes := int64(1)
+		for field, val := range fields {
+			if field == node {
+				continue
+			}
+			if c, ok := parseClusterLease(val, now); ok {
+				others += c
+				nodes++
+			}
+		}
+		m.setClusterOthers(key, others, nodes)
+	}
+}
+
+// parseClusterLease 解析 连接数:租约到期时间 租约已过期时返回false
+func parseClusterLease(val string, now int64) (int64, bool) {
+	vals := strings.SplitN(val, ":", 2)
+	if len(vals) != 2 {
+		return 0, false
+	}
+	c, err := strconv.ParseInt(vals[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	exp, err := strconv.ParseInt(vals[1], 10, 64)
+	if err != nil || exp < now {
+		return 0, false
+	}
+	return c, true
+}
+
+func (m *manager) clearClusterConn(ctx context.Context) {
+	node := clusterNodeId()
+	pipe := common.GetRedisDB().Pipeline()
+	n := 0
+	for v := range m.clusterConnMap.Iter() {
+		pipe.HDel(ctx, v.Key, node)
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Error("[cluster_limit] 删除本节点集群连接数失败", zap.Error(err))
+	}
+}
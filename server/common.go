@@ -4,11 +4,6 @@ import (
 	"regexp"
 )
 
-type BlacklistBroadcastMsg struct {
-	Ts        int64    `json:"ts"`
-	Blacklist []string `json:"blacklist"`
-}
-
 // regexpDomain 函数用于从给定的文本中提取域名。
 func regexpDomain(text string) string {
 	domainRegex := regexp.MustCompile(`([a-zA-Z0-9.-]+\.[a-zA-Z]{2,})`)
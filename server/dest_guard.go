@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+
+	"proxy_server/config"
+	"proxy_server/log"
+	"proxy_server/utils/ipGuard"
+)
+
+// errDestDenied 目标解析出的地址在禁止访问的网段内
+type errDestDenied struct {
+	ip   string
+	cidr string
+}
+
+func (e *errDestDenied) Error() string {
+	return fmt.Sprintf("目标地址%s在禁止访问的网段%s内", e.ip, e.cidr)
+}
+
+// rule 上报的规则标识 与黑名单命中记录一起上报
+func (e *errDestDenied) rule() string {
+	return "deny_cidr:" + e.cidr
+}
+
+// asDestDenied 判断拨号错误是否为目标地址被禁止
+func asDestDenied(err error) (*errDestDenied, bool) {
+	var denied *errDestDenied
+	ok := errors.As(err, &denied)
+	return denied, ok
+}
+
+// updateDestGuard 按配置重建目标地址检测 本节点地址及redis、rabbitmq地址始终禁止访问
+func (m *manager) updateDestGuard() {
+	conf := m.getNacosConf().DestGuard
+	if conf.Disable {
+		m.destGuard.Store(nil)
+		return
+	}
+
+	cidrs := conf.DenyCIDRs
+	if len(cidrs) == 0 {
+		cidrs = ipGuard.DefaultDenyCIDRs
+	}
+	guard, err := ipGuard.New(cidrs)
+	if err != nil {
+		log.Error("[dest_guard] 禁止访问网段配置错误 使用默认网段", zap.Error(err))
+		guard, _ = ipGuard.New(ipGuard.DefaultDenyCIDRs)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	for _, host := range serviceHosts() {
+		ips, err := lookupHost(ctx, host)
+		if err != nil {
+			log.Error("[dest_guard] 解析依赖服务地址失败", zap.Error(err), zap.Any("host", host))
+			continue
+		}
+		for _, ip := range ips {
+			guard.AddIP(ip)
+		}
+	}
+	for _, ip := range localIPs() {
+		guard.AddIP(ip)
+	}
+	m.destGuard.Store(guard)
+}
+
+// localIPs 本节点的网卡地址及监听地址 避免经代理访问本节点的调试接口或回环到代理端口
+func localIPs() []net.IP {
+	ips := []net.IP{}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Error("[dest_guard] 读取网卡地址失败", zap.Error(err))
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+
+	conf := config.GetConf()
+	listeners := append([]string{conf.GrpcListenerAddress}, conf.TcpListenerAddress...)
+	for _, address := range listeners {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			ips = append(ips, ip)
+		}
+	}
+	if ip := net.ParseIP(conf.LocalIp); ip != nil {
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// serviceHosts 节点依赖的redis、rabbitmq主机
+func serviceHosts() []string {
+	hosts := []string{}
+	conf := config.GetConf()
+	if conf.Redis != nil && conf.Redis.Addr != "" {
+		host, _, err := net.SplitHostPort(conf.Redis.Addr)
+		if err != nil {
+			host = conf.Redis.Addr
+		}
+		hosts = append(hosts, host)
+	}
+	if conf.Rabbitmq != nil && conf.Rabbitmq.Host != "" {
+		hosts = append(hosts, conf.Rabbitmq.Host)
+	}
+	return hosts
+}
+
+func lookupHost(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// dialDest 解析目标并检测所有地址 任一地址被禁止时返回errDestDenied
+// 检测通过后直接拨号解析出的ip 避免dns重绑定绕过检测
+func (m *manager) dialDest(ctx context.Context, address string, timeout time.Duration, localIP []byte) (net.Conn, error) {
+	guard := m.destGuard.Load()
+	if guard == nil {
+		return DialContext(ctx, "tcp", address, timeout, localIP, 0)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+	ips, err := lookupHost(lookupCtx, host)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if cidr, deny := guard.Deny(ip); deny {
+			return nil, &errDestDenied{ip: ip.String(), cidr: cidr}
+		}
+	}
+
+	// 出口ip为ipv4时只拨号ipv4地址 反之亦然
+	localIs4 := net.IP(localIP).To4() != nil
+	err = fmt.Errorf("目标%s没有可用的地址", host)
+	for _, ip := range ips {
+		if len(localIP) != 0 && (ip.To4() != nil) != localIs4 {
+			continue
+		}
+		var conn net.Conn
+		conn, err = DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port), timeout, localIP, 0)
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
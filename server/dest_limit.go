@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"proxy_server/utils/rateLimit"
+)
+
+// DestLimitRule 单个目标域名后缀的限制 按(出口ip, 规则域名)分别计数
+type DestLimitRule struct {
+	Domain      string // 域名后缀 匹配自身及其子域名
+	MaxConn     int    // 单个出口ip到该域名的最大并发连接数 0表示不限制
+	NewConnRate int    // 单个出口ip到该域名每秒最多新建连接数 0表示不限制
+}
+
+// destContext 单个(出口ip, 规则域名)的连接计数
+type destContext struct {
+	c       int64
+	newConn *rateLimit.Bucket // 新建连接速率 未配置时为nil
+}
+
+// destConnection 单个连接占用的目标域名额度
+type destConnection struct {
+	key string
+}
+
+// errDestLimit 目标域名连接数超限 以dest_limit_reached返回给客户端 与其他连接数限制区分
+type errDestLimit struct {
+	msg string
+}
+
+func (e *errDestLimit) Error() string {
+	return e.msg
+}
+
+// destLimitReason 返回目标域名限制的拒绝原因
+func destLimitReason(err error) string {
+	var destErr *errDestLimit
+	if errors.As(err, &destErr) {
+		return REJECT_DEST_LIMITED
+	}
+	return REJECT_LIMIT_REACHED
+}
+
+// matchDestLimitRule 返回匹配的最长域名后缀规则
+func matchDestLimitRule(rules []DestLimitRule, domain string) *DestLimitRule {
+	var matched *DestLimitRule
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Domain == "" || (rule.MaxConn <= 0 && rule.NewConnRate <= 0) {
+			continue
+		}
+		if domain != rule.Domain && !strings.HasSuffix(domain, "."+rule.Domain) {
+			continue
+		}
+		if matched == nil || len(rule.Domain) > len(matched.Domain) {
+			matched = rule
+		}
+	}
+	return matched
+}
+
+// addDestConnection 占用出口ip到目标域名的连接额度 未匹配规则时返回nil
+// 连接结束后需调用deleteDestConnection释放
+func (m *manager) addDestConnection(ip, domain string) (*destConnection, error) {
+	rule := matchDestLimitRule(m.getNacosConf().DestLimit, strings.ToLower(domain))
+	if rule == nil {
+		return nil, nil
+	}
+
+	key := fmt.Sprintf("%s|%s", ip, rule.Domain)
+	var resErr error
+	m.destConnMap.Upsert(key, nil, func(exist bool, valueInMap *destContext, newValue *destContext) *destContext {
+		if !exist {
+			valueInMap = &destContext{}
+		}
+
+		if rule.NewConnRate > 0 {
+			if valueInMap.newConn == nil {
+				valueInMap.newConn = rateLimit.NewBucket(rule.NewConnRate, rule.NewConnRate)
+			} else if valueInMap.newConn.Rate() != rule.NewConnRate {
+				valueInMap.newConn.SetLimit(rule.NewConnRate, rule.NewConnRate)
+			}
+			if !valueInMap.newConn.AllowN(1) {
+				resErr = &errDestLimit{fmt.Sprintf("出口ip%s到%s新建连接速率达到上限 new_conn_rate:%d", ip, rule.Domain, rule.NewConnRate)}
+				return valueInMap
+			}
+		} else {
+			valueInMap.newConn = nil
+		}
+
+		if rule.MaxConn > 0 && valueInMap.c >= int64(rule.MaxConn) {
+			resErr = &errDestLimit{fmt.Sprintf("出口ip%s到%s连接数达到上限 max_conn:%d", ip, rule.Domain, rule.MaxConn)}
+			return valueInMap
+		}
+		valueInMap.c++
+		return valueInMap
+	})
+
+	if resErr != nil {
+		m.countDestReject(rule.Domain)
+		m.releaseDestContext(key)
+		return nil, resErr
+	}
+	return &destConnection{key: key}, nil
+}
+
+func (m *manager) deleteDestConnection(dc *destConnection) {
+	if dc == nil {
+		return
+	}
+	m.destConnMap.RemoveCb(dc.key, func(key string, valueInMap *destContext, exists bool) bool {
+		if !exists {
+			return false
+		}
+		valueInMap.c--
+		return valueInMap.c <= 0 && (valueInMap.newConn == nil || valueInMap.newConn.Full())
+	})
+}
+
+// releaseDestContext 被拒绝时删除未占用的计数
+func (m *manager) releaseDestContext(key string) {
+	m.destConnMap.RemoveCb(key, func(key string, valueInMap *destContext, exists bool) bool {
+		return exists && valueInMap.c <= 0 && (valueInMap.newConn == nil || valueInMap.newConn.Full())
+	})
+}
+
+// countDestReject 按规则域名统计拒绝次数
+func (m *manager) countDestReject(domain string) {
+	m.destRejectTotal.Add(1)
+	m.destRejectMap.Upsert(domain, nil, func(exist bool, valueInMap *atomic.Uint64, newValue *atomic.Uint64) *atomic.Uint64 {
+		if !exist {
+			valueInMap = &atomic.Uint64{}
+		}
+		valueInMap.Add(1)
+		return valueInMap
+	})
+}
+
+func (m *manager) destRejectStats() (uint64, map[string]uint64) {
+	stats := map[string]uint64{}
+	for v := range m.destRejectMap.Iter() {
+		stats[v.Key] = v.Val.Load()
+	}
+	return m.destRejectTotal.Load(), stats
+}
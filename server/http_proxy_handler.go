@@ -17,41 +17,66 @@ import (
 	"go.uber.org/zap"
 
 	"proxy_server/log"
+	"proxy_server/protobuf"
 	"proxy_server/server/sniffing"
 	"proxy_server/server/sniffing/tls"
+	"proxy_server/utils/blacklistRule"
 )
 
 func (m *manager) httpTcpConn(ctx context.Context, conn net.Conn, req *http.Request) {
+	stats := newSessionStats(time.Now())
 	auth := req.Header.Get("Proxy-Authorization")
-	auth = strings.Replace(auth, "Basic ", "", 1)
-	authData, err := base64.StdEncoding.DecodeString(auth)
-	if err != nil {
-		log.Error("[tcp_conn_handler] http代理Proxy-Authorization获取失败", zap.Error(err), zap.Any("auth", auth))
-		if _, err = conn.Write([]byte("HTTP/1.1 407 Proxy Authorization Required\r\nProxy-Authenticate: Basic realm=\"Secure Proxys\"\r\n\r\n")); err != nil {
+	proxyServerConn := conn.LocalAddr().(*net.TCPAddr)
+	proxyServerIpStr := proxyServerConn.IP.String()
+	clientIpStr := conn.RemoteAddr().(*net.TCPAddr).IP.String()
+
+	var (
+		authInfo      *protobuf.AuthInfo
+		proxyUserName string
+		proxyPassword string
+		err           error
+	)
+	if strings.HasPrefix(auth, "Bearer ") {
+		// 令牌鉴权 账号由令牌决定
+		authInfo, err = m.ValidToken(ctx, strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")), proxyServerIpStr, clientIpStr)
+		if err != nil {
+			log.Error("[tcp_conn_handler] http代理令牌鉴权失败", zap.Error(err), zap.Any("client_ip", clientIpStr))
+			if err = writeHttpReject(conn, REJECT_AUTH_FAILED, "Proxy-Authenticate: Bearer realm=\"Secure Proxys\""); err != nil {
+				return
+			}
+			return
+		}
+		proxyUserName = authInfo.Username
+	} else {
+		auth = strings.Replace(auth, "Basic ", "", 1)
+		authData, err := base64.StdEncoding.DecodeString(auth)
+		if err != nil {
+			log.Error("[tcp_conn_handler] http代理Proxy-Authorization获取失败", zap.Error(err), zap.Any("auth", auth))
+			if err = writeHttpReject(conn, REJECT_AUTH_FAILED, "Proxy-Authenticate: Basic realm=\"Secure Proxys\""); err != nil {
+				return
+			}
 			return
 		}
-		return
-	}
 
-	userPasswdPair := strings.Split(string(authData), ":")
-	if len(userPasswdPair) != 2 {
-		log.Error("[tcp_conn_handler] http代理账号密码错误", zap.Any("authData", authData))
-		if _, err = conn.Write([]byte("HTTP/1.1 407 Proxy Authorization Required\r\nProxy-Authenticate: Basic realm=\"Secure Proxys\"\r\n\r\n")); err != nil {
+		userPasswdPair := strings.SplitN(string(authData), ":", 2)
+		if len(userPasswdPair) != 2 {
+			log.Error("[tcp_conn_handler] http代理账号密码错误", zap.Any("authData", authData))
+			if err = writeHttpReject(conn, REJECT_AUTH_FAILED, "Proxy-Authenticate: Basic realm=\"Secure Proxys\""); err != nil {
+				return
+			}
 			return
 		}
-		return
-	}
 
-	proxyServerConn := conn.LocalAddr().(*net.TCPAddr)
-	proxyUserName := userPasswdPair[0]
-	proxyPassword := userPasswdPair[1]
-	proxyServerIpStr := proxyServerConn.IP.String()
-	if _, err := m.Valid(ctx, proxyUserName, proxyPassword, proxyServerIpStr); err != nil {
-		log.Error("[tcp_conn_handler] http代理鉴权失败", zap.Error(err))
-		if _, err = conn.Write([]byte("HTTP/1.1 407 Proxy Authorization Required\r\nProxy-Authenticate: Basic realm=\"Secure Proxys\"\r\n\r\n")); err != nil {
+		proxyUserName = userPasswdPair[0]
+		proxyPassword = userPasswdPair[1]
+		authInfo, err = m.Valid(ctx, proxyUserName, proxyPassword, proxyServerIpStr, clientIpStr)
+		if err != nil {
+			log.Error("[tcp_conn_handler] http代理鉴权失败", zap.Error(err))
+			if err = writeHttpReject(conn, REJECT_AUTH_FAILED, "Proxy-Authenticate: Basic realm=\"Secure Proxys\""); err != nil {
+				return
+			}
 			return
 		}
-		return
 	}
 
 	if ok, ipCount := m.AddIpConnCount(proxyServerIpStr); ok {
@@ -59,13 +84,21 @@ func (m *manager) httpTcpConn(ctx context.Context, conn net.Conn, req *http.Requ
 	} else {
 		///ip的连接数到达上限
 		log.Error("[tcp_conn_handler] ip连接数到达上线", zap.Any("ip", proxyServerIpStr), zap.Any("user", proxyUserName), zap.Any("连接数", ipCount))
-		if _, err = conn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\n\r\n")); err != nil {
+		if err = writeHttpReject(conn, REJECT_LIMIT_REACHED); err != nil {
 			return
 		}
 		return
 
 	}
 
+	if err = m.checkUserQuota(ctx, authInfo); err != nil {
+		log.Error("[tcp_conn_handler] 流量配额已用完", zap.Error(err), zap.Any("user", proxyUserName))
+		if err = writeHttpReject(conn, REJECT_QUOTA_EXCEEDED); err != nil {
+			return
+		}
+		return
+	}
+
 	address := req.Host
 	_, port, _ := net.SplitHostPort(req.Host)
 	if req.Method == "CONNECT" {
@@ -79,31 +112,102 @@ func (m *manager) httpTcpConn(ctx context.Context, conn net.Conn, req *http.Requ
 	}
 
 	domain := regexpDomain(address)
+	// 协议 CONNECT请求在转发时识别
+	var protocolPointer atomic.Pointer[string]
+	if req.Method != "CONNECT" {
+		protocol := blacklistRule.PROTOCOL_HTTP
+		protocolPointer.Store(&protocol)
+	}
+	if m.blacklistUnavailable() {
+		log.Error("[tcp_conn_handler] 严格模式下黑名单未加载 拒绝连接", zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", address), zap.Any("user", proxyUserName))
+		if err = writeHttpReject(conn, REJECT_UNAVAILABLE); err != nil {
+			return
+		}
+		return
+	}
+	blackTarget := blacklistTarget(domain, address, derefString(protocolPointer.Load()))
+	if black, in := m.matchBlacklist(blackTarget); in {
+		m.SendBlackListAccessLogMessageData(proxyUserName, proxyServerIpStr, black, 1, proxyUserName, proxyServerIpStr, false)
+		log.Error("[tcp_conn_handler] 黑名单", zap.Any("domain", domain), zap.Any("rule", black), zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", address), zap.Any("user", proxyUserName))
+		if err = writeHttpReject(conn, REJECT_BLACKLISTED); err != nil {
+			return
+		}
+		return
+	}
+	if reason, ok := m.checkUserPolicy(authInfo, blackTarget); !ok {
+		log.Error("[tcp_conn_handler] 账号访问策略拒绝", zap.Any("reason", reason), zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", address), zap.Any("user", proxyUserName))
+		if err = writeHttpReject(conn, REJECT_BLACKLISTED); err != nil {
+			return
+		}
+		return
+	}
+	if reason, ok := m.checkPortPolicy(authInfo, address); !ok {
+		stats.closeReason = CLOSE_REASON_PORT
+		m.ReportAccessLogToInfluxDB(proxyUserName, address, proxyServerConn.String(), conn.RemoteAddr().String(), stats)
+		log.Error("[tcp_conn_handler] 目标端口禁止访问", zap.Any("reason", reason), zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", address), zap.Any("user", proxyUserName))
+		if err = writeHttpReject(conn, REJECT_PORT_DENIED); err != nil {
+			return
+		}
+		return
+	}
+
 	if domain != "" {
-		if black, in := m.IsInBlacklist(domain); in {
-			m.SendBlackListAccessLogMessageData(proxyUserName, proxyPassword, black, 1, proxyUserName, proxyServerIpStr)
-			log.Error("[tcp_conn_handler] 黑名单", zap.Any("domain", domain), zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", address), zap.Any("user", proxyUserName))
-			if _, err = conn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\n\r\n")); err != nil {
+		destConn, err := m.addDestConnection(proxyServerIpStr, domain)
+		if err != nil {
+			log.Error("[tcp_conn_handler] 目标域名连接数到达上限", zap.Error(err), zap.Any("ip", proxyServerIpStr), zap.Any("user", proxyUserName))
+			if err = writeHttpReject(conn, destLimitReason(err)); err != nil {
 				return
 			}
 			return
 		}
+		defer m.deleteDestConnection(destConn)
 	}
 
 	var domainPointer atomic.Pointer[string]
 	domainPointer.Store(&domain)
 
 	var target net.Conn
-	target, err = DialContext(ctx, "tcp", address, time.Second*10, proxyServerConn.IP, 0)
+	target, err = m.dialDest(ctx, address, time.Second*10, proxyServerConn.IP)
+	if denied, ok := asDestDenied(err); ok {
+		m.SendBlackListAccessLogMessageData(proxyUserName, proxyServerIpStr, denied.rule(), 1, proxyUserName, proxyServerIpStr, false)
+		log.Error("[tcp_conn_handler] 目标地址禁止访问", zap.Error(err), zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", address), zap.Any("user", proxyUserName))
+		if err = writeHttpReject(conn, REJECT_BLACKLISTED); err != nil {
+			return
+		}
+		return
+	}
 	if err != nil {
 		log.Error("[tcp_conn_handler] 创建目标连接失败", zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", address), zap.Any("user", proxyUserName))
-		if _, err = conn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\n\r\n")); err != nil {
+		if err = writeHttpReject(conn, REJECT_UPSTREAM_FAILURE); err != nil {
 			return
 		}
 		return
 	}
 	defer target.Close()
 
+	// 通过全部检测并连接目标成功后再占用账号及集群额度 避免被拒绝的请求挤掉账号已有的连接
+	sessionCtx, sessionCancel := context.WithCancel(ctx)
+	defer sessionCancel()
+	accountConn, err := m.addAccountConnection(ctx, authInfo, sessionCancel)
+	if err != nil {
+		log.Error("[tcp_conn_handler] 账号连接数到达上限", zap.Error(err), zap.Any("ip", proxyServerIpStr), zap.Any("user", proxyUserName))
+		if err = writeHttpReject(conn, REJECT_LIMIT_REACHED); err != nil {
+			return
+		}
+		return
+	}
+	defer m.deleteAccountConnection(accountConn)
+
+	clusterConn, err := m.addClusterConnection(ctx, proxyUserName, proxyServerIpStr)
+	if err != nil {
+		log.Error("[tcp_conn_handler] 集群连接数到达上限", zap.Error(err), zap.Any("ip", proxyServerIpStr), zap.Any("user", proxyUserName))
+		if err = writeHttpReject(conn, REJECT_LIMIT_REACHED); err != nil {
+			return
+		}
+		return
+	}
+	defer m.deleteClusterConnection(clusterConn)
+
 	if req.Method == "CONNECT" {
 		if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
 			return
@@ -137,9 +241,40 @@ func (m *manager) httpTcpConn(ctx context.Context, conn net.Conn, req *http.Requ
 	}
 
 	key := fmt.Sprintf("%s:%s", proxyUserName, proxyServerIpStr)
-	connCtx := m.addUserConnection(key)
-	action := connCtx.a
+	connCtx := m.addUserConnection(key, authInfo)
+	blockCtx, blockCancel := context.WithCancel(ctx)
+	defer blockCancel()
+	session := m.addLiveSession(accountConn.id, authInfo, &sessionTarget{
+		address:  address,
+		domain:   &domainPointer,
+		protocol: &protocolPointer,
+		authInfo: &connCtx.authInfo,
+		onBlock: func(rule string, global bool) {
+			if global {
+				m.SendBlackListAccessLogMessageData(proxyUserName, proxyServerIpStr, rule, 1, proxyUserName, proxyServerIpStr, false)
+			}
+			log.Error("[tcp_conn_handler] 黑名单 关闭连接",
+				zap.Any("domain", domainPointer.Load()),
+				zap.Any("rule", rule),
+				zap.Any("global", global),
+				zap.Any("username", proxyUserName),
+				zap.Any("local_ip", proxyServerIpStr),
+				zap.Any("target_host", address),
+			)
+			blockCancel()
+		},
+		onMonitor: func(rule string) {
+			m.SendBlackListAccessLogMessageData(proxyUserName, proxyServerIpStr, rule, 1, proxyUserName, proxyServerIpStr, true)
+			log.Info("[tcp_conn_handler] 黑名单观察模式命中 不拦截", zap.Any("rule", rule), zap.Any("username", proxyUserName), zap.Any("target_host", address))
+		},
+	})
+	defer m.deleteLiveSession(session)
+	ipAction := m.getIpLimitedReaderAction(proxyServerIpStr)
+	upActions := m.bandwidthActions(session, connCtx.up, accountConn.actions, ipAction)
+	downActions := m.bandwidthActions(session, connCtx.down, accountConn.actions, ipAction)
 	defer m.deleteUserConnection(key, connCtx)
+	traffic := m.addUserTraffic(proxyUserName, authInfo)
+	defer m.deleteUserTraffic(proxyUserName, traffic)
 
 	var netConn, netTarget io.ReadWriteCloser
 
@@ -152,30 +287,36 @@ func (m *manager) httpTcpConn(ctx context.Context, conn net.Conn, req *http.Requ
 	errCh := make(chan error, 2)
 	defer close(errCh)
 
+	// 转发读取使用会话自己的ctx connCtx.ctx由同一用户出口ip的会话共享 会话结束时不会取消
+	relayCtx, relayCancel := context.WithCancel(connCtx.ctx)
 	done := make(chan struct{})
 	wg := sync.WaitGroup{}
 	defer func() {
+		// 先取消正在等待限速额度的读取 避免等完全部排队额度才结束 未使用的额度退回
+		relayCancel()
 		close(done)
 		netConn.Close()
 		netTarget.Close()
 
+		// 等待转发结束后再上报 保证统计的字节数完整
+		wg.Wait()
+
+		clientAddr := conn.RemoteAddr().String()
 		domain := domainPointer.Load()
 		if domain != nil && *domain != "" {
-			m.ReportAccessLogToInfluxDB(proxyUserName, *domain, proxyServerConn.String())
+			m.ReportAccessLogToInfluxDB(proxyUserName, *domain, proxyServerConn.String(), clientAddr, stats)
 		} else {
 			hostArr := strings.Split(address, ":")
 			if cap(hostArr) > 0 {
-				m.ReportAccessLogToInfluxDB(proxyUserName, hostArr[0], proxyServerConn.String())
+				m.ReportAccessLogToInfluxDB(proxyUserName, hostArr[0], proxyServerConn.String(), clientAddr, stats)
 			} else {
-				m.ReportAccessLogToInfluxDB(proxyUserName, address, proxyServerConn.String())
+				m.ReportAccessLogToInfluxDB(proxyUserName, address, proxyServerConn.String(), clientAddr, stats)
 			}
 		}
-
-		wg.Wait()
 	}()
 
-	///域名为空，并且使用CONNECT
-	if domain == "" && req.Method == "CONNECT" {
+	///使用CONNECT时识别协议 域名为空时同时识别域名
+	if req.Method == "CONNECT" {
 		readWriterNotice, err := sniffing.NewReadWriterNotice(
 			netConn,
 			nil,
@@ -189,6 +330,8 @@ func (m *manager) httpTcpConn(ctx context.Context, conn net.Conn, req *http.Requ
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			// 识别出域名或协议后立即检测
+			defer m.checkLiveSession(session)
 			select {
 			case <-done:
 				return
@@ -202,8 +345,12 @@ func (m *manager) httpTcpConn(ctx context.Context, conn net.Conn, req *http.Requ
 						// 尝试将负载数据反序列化为 ClientHelloMsg 实例
 						clientHelloMsg.UnmarshalByByte(buf)
 						// 如果反序列化后得到了 ServerName
+						protocol := blacklistRule.PROTOCOL_TLS
+						protocolPointer.Store(&protocol)
 						if clientHelloMsg.ServerName != "" {
-							domainPointer.Store(&clientHelloMsg.ServerName)
+							if domain == "" {
+								domainPointer.Store(&clientHelloMsg.ServerName)
+							}
 							return
 						}
 					}
@@ -212,15 +359,23 @@ func (m *manager) httpTcpConn(ctx context.Context, conn net.Conn, req *http.Requ
 					hr, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf)))
 					// 如果解析成功
 					if err == nil {
+						protocol := blacklistRule.PROTOCOL_HTTP
+						protocolPointer.Store(&protocol)
 						// 从 HTTP 请求头中获取 Host 字段作为 ServerName
 						ServerName := hr.Header.Get("Host")
 						if ServerName != "" {
-							domainPointer.Store(&ServerName)
+							if domain == "" {
+								domainPointer.Store(&ServerName)
+							}
 							return
 						}
 
 					}
 
+					if domain != "" {
+						return
+					}
+
 					ServerName := regexpDomain(string(buf))
 
 					if ServerName != "" {
@@ -235,42 +390,29 @@ func (m *manager) httpTcpConn(ctx context.Context, conn net.Conn, req *http.Requ
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		_, err := io.CopyBuffer(netTarget, NewLimitedReader(connCtx.ctx, netConn, action), make([]byte, 2*1024))
+		_, err := io.CopyBuffer(netTarget, NewLimitedReader(relayCtx, newCountReader(netConn, &traffic.pending, &stats.upload), upActions...), make([]byte, 2*1024))
 		errCh <- err
 	}()
 
 	go func() {
 		defer wg.Done()
-		_, err := io.CopyBuffer(netConn, NewLimitedReader(connCtx.ctx, netTarget, action), make([]byte, 2*1024))
+		_, err := io.CopyBuffer(netConn, NewLimitedReader(relayCtx, newCountReader(netTarget, &traffic.pending, &stats.download), downActions...), make([]byte, 2*1024))
 		errCh <- err
 	}()
 
-	loopTime := 30 * time.Second
-	ticker := time.NewTicker(loopTime)
-	defer ticker.Stop()
-
 	for {
-		ticker.Reset(loopTime)
 		select {
-
-		case <-ticker.C:
-			domain := domainPointer.Load()
-			if domain != nil && *domain != "" {
-				if black, in := m.IsInBlacklist(*domain); in {
-					m.SendBlackListAccessLogMessageData(proxyUserName, proxyPassword, black, 1, proxyUserName, proxyServerIpStr)
-					log.Error("[tcp_conn_handler] 黑名单定时检测",
-						zap.Any("domain", domain),
-						zap.Error(err),
-						zap.Any("username", proxyUserName),
-						zap.Any("clientAddr", proxyServerIpStr),
-						zap.Any("target_host", address),
-					)
-
-					return
-				}
+		case <-blockCtx.Done():
+			if ctx.Err() == nil {
+				stats.closeReason = CLOSE_REASON_BLACKLIST
+				return
 			}
+			stats.closeReason = CLOSE_REASON_SHUTDOWN
+			return
 		case err, _ := <-errCh:
+			stats.closeReason = CLOSE_REASON_EOF
 			if err != nil {
+				stats.closeReason = CLOSE_REASON_ERROR
 				log.Error("[tcp_conn_handler] conn close!",
 					zap.Error(err),
 					zap.Any("username", proxyUserName),
@@ -281,8 +423,13 @@ func (m *manager) httpTcpConn(ctx context.Context, conn net.Conn, req *http.Requ
 
 			return
 		case <-ctx.Done():
+			stats.closeReason = CLOSE_REASON_SHUTDOWN
 			return
 		case <-connCtx.ctx.Done():
+			stats.closeReason = CLOSE_REASON_KICKED
+			return
+		case <-sessionCtx.Done():
+			stats.closeReason = CLOSE_REASON_EVICTED
 			return
 
 		}
@@ -6,6 +6,8 @@ import (
 	"net"
 	"sync/atomic"
 	"time"
+
+	"proxy_server/protobuf"
 )
 
 const (
@@ -28,17 +30,28 @@ const (
 	DISCONNECT_CHANNEL        = "disconnect"
 	REDIS_AUTH_USERDATA       = "auth_user_data"
 	REDIS_USER_IPSET          = "user_ip_set"
+	REDIS_AUTH_TOKEN          = "auth_token"
+	REDIS_TRAFFIC_DAY         = "user_traffic_day"
+	REDIS_TRAFFIC_MONTH       = "user_traffic_month"
+	REDIS_TRAFFIC_BATCH       = "user_traffic_batch"
+	REDIS_CLUSTER_CONN        = "cluster_conn"
+	REDIS_BLACKLIST           = "blacklist_snapshot"
+	TOKEN_PASSWORD_PRE        = "token:" // socks5密码以此开头时 其后内容作为令牌鉴权
 )
 
 type connContext struct {
 	ctx    context.Context
 	cancel context.CancelFunc
-	a      *LimitedReaderAction
+	up     *LimitedReaderAction // 上行限速 客户端->目标
+	down   *LimitedReaderAction // 下行限速 目标->客户端
 	c      uint64
+
+	authInfo atomic.Pointer[protobuf.AuthInfo] // 最近一次鉴权得到的账号信息 用于检测账号失效
 }
 
 type IpConnCountMapData struct {
 	count atomic.Int64
+	a     *LimitedReaderAction // 出口ip带宽限速
 }
 
 func newConn(conn net.Conn, writetimeout, readtimeout int64) io.ReadWriteCloser {
@@ -0,0 +1,58 @@
+package lua
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"proxy_server/common"
+	"proxy_server/log"
+)
+
+// ClusterConnLuaScript 集群连接数租约 哈希字段为节点 值为 连接数:租约到期时间
+// 返回 {是否成功, 其他节点连接数, 持有有效租约的节点数(包含本节点)}
+const ClusterConnLuaScript = `
+local key = KEYS[1]
+
+local node = ARGV[1]
+local count = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+local lease = tonumber(ARGV[5])
+
+local others = 0
+local nodes = 1
+local fields = redis.call('HGETALL', key)
+for i = 1, #fields, 2 do
+    if fields[i] ~= node then
+        local c, exp = string.match(fields[i + 1], '^(%d+):(%d+)$')
+        if c == nil or tonumber(exp) < now then
+            redis.call('HDEL', key, fields[i])
+        else
+            others = others + tonumber(c)
+            nodes = nodes + 1
+        end
+    end
+end
+
+if others + count > limit then
+    return {0, others, nodes}
+end
+
+redis.call('HSET', key, node, count .. ':' .. (now + lease))
+redis.call('EXPIRE', key, lease * 2)
+
+return {1, others, nodes}
+`
+
+var ClusterConnLuaScriptShaCode string
+
+func init() {
+	// 加载 Lua 脚本
+	script := redis.NewScript(ClusterConnLuaScript)
+	sha, err := script.Load(context.Background(), common.GetRedisDB()).Result()
+	if err != nil {
+		log.Panic("[lua] 加载ClusterConnLuaScript失败", zap.Error(err))
+	}
+	ClusterConnLuaScriptShaCode = sha
+}
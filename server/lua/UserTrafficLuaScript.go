@@ -0,0 +1,46 @@
+package lua
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"proxy_server/common"
+	"proxy_server/log"
+)
+
+// UserTrafficLuaScript 原子累加账号当天及当月流量 同一批次只累加一次 避免重试时重复计数
+// 返回 {当天流量, 当月流量}
+const UserTrafficLuaScript = `
+local dayKey = KEYS[1]
+local monthKey = KEYS[2]
+local batchKey = KEYS[3]
+
+local bytes = tonumber(ARGV[1])
+local dayTTL = tonumber(ARGV[2])
+local monthTTL = tonumber(ARGV[3])
+local batchTTL = tonumber(ARGV[4])
+
+if not redis.call('SET', batchKey, 1, 'NX', 'EX', batchTTL) then
+    return {tonumber(redis.call('GET', dayKey) or 0), tonumber(redis.call('GET', monthKey) or 0)}
+end
+
+local day = redis.call('INCRBY', dayKey, bytes)
+redis.call('EXPIRE', dayKey, dayTTL)
+local month = redis.call('INCRBY', monthKey, bytes)
+redis.call('EXPIRE', monthKey, monthTTL)
+
+return {day, month}
+`
+
+var UserTrafficLuaScriptShaCode string
+
+func init() {
+	// 加载 Lua 脚本
+	script := redis.NewScript(UserTrafficLuaScript)
+	sha, err := script.Load(context.Background(), common.GetRedisDB()).Result()
+	if err != nil {
+		log.Panic("[lua] 加载UserTrafficLuaScript失败", zap.Error(err))
+	}
+	UserTrafficLuaScriptShaCode = sha
+}
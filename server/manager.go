@@ -15,7 +15,10 @@ import (
 
 	"proxy_server/protobuf"
 	"proxy_server/utils/Queue"
+	"proxy_server/utils/blacklistRule"
+	"proxy_server/utils/ipGuard"
 	"proxy_server/utils/rabbitMQ"
+	"proxy_server/utils/rateLimit"
 	"proxy_server/utils/taskConsumerManager"
 )
 
@@ -27,6 +30,17 @@ var newManager = sync.OnceValue(func() *manager {
 		tcm:            taskConsumerManager.New(), // 任务消费者管理器
 		ipConnCountMap: cmap.New[*IpConnCountMapData](),
 		userCtxMap:     cmap.New[*connContext](),
+		authSnapshot:   cmap.New[*authSnapshotEntry](),
+		accountCtxMap:  cmap.New[*accountContext](),
+		userTrafficMap: cmap.New[*userTraffic](),
+		quotaEventMap:  cmap.New[string](),
+		sessionMap:     cmap.New[*liveSession](),
+		sessionHostMap: cmap.New[sessionSet](),
+		clusterConnMap: cmap.New[*clusterCounter](),
+		destConnMap:    cmap.New[*destContext](),
+		destRejectMap:  cmap.New[*atomic.Uint64](),
+		userPolicyMap:  cmap.New[*userPolicy](),
+		monitorHitMap:  cmap.New[*atomic.Uint64](),
 	}
 	m.isRun.Store(true)
 	m.bytePool = sync.Pool{
@@ -50,20 +64,44 @@ type manager struct {
 	bytePool                       sync.Pool
 	ipConnCountMap                 cmap.ConcurrentMap[string, *IpConnCountMapData]
 	userCtxMap                     cmap.ConcurrentMap[string, *connContext]
+	accountCtxMap                  cmap.ConcurrentMap[string, *accountContext] // 账号级别连接计数及限速 包含上级账号
+	userTrafficMap                 cmap.ConcurrentMap[string, *userTraffic]    // 账号待上报流量
+	quotaEventMap                  cmap.ConcurrentMap[string, string]          // 账号 -> 已上报配额耗尽事件的周期
+	sessionCounter                 atomic.Uint64                               // 会话id生成器
+	sessionMap                     cmap.ConcurrentMap[string, *liveSession]    // 正在转发的会话
+	sessionHostMap                 cmap.ConcurrentMap[string, sessionSet]      // 目标域名或ip -> 会话 黑名单更新时按目标检测
+	nodeScheduler                  *rateLimit.Scheduler                        // 节点总带宽限速 按服务等级加权公平调度
+	clusterConnMap                 cmap.ConcurrentMap[string, *clusterCounter] // 集群计数键 -> 本节点连接数
+	destConnMap                    cmap.ConcurrentMap[string, *destContext]    // 出口ip|目标域名 -> 连接计数
+	destRejectMap                  cmap.ConcurrentMap[string, *atomic.Uint64]  // 目标域名规则 -> 拒绝次数
+	monitorHitMap                  cmap.ConcurrentMap[string, *atomic.Uint64]  // 观察模式规则 -> 命中次数
+	destRejectTotal                atomic.Uint64
+	userPolicyMap                  cmap.ConcurrentMap[string, *userPolicy] // 账号 -> 编译后的允许及禁止列表
 	nacosConfig                    *NacosConfig
 	nacosConfigMu                  sync.RWMutex
 	viperClient                    *viper.Viper
-	blackMap                       atomic.Pointer[map[string]struct{}]
+	blacklist                      atomic.Pointer[blacklistRule.Matcher]
+	blacklistMonitor               atomic.Pointer[blacklistRule.Matcher]
+	blacklistState                 *blacklistState // 受blacklistMu保护
+	blacklistMu                    sync.Mutex
+	destGuard                      atomic.Pointer[ipGuard.Guard] // 目标地址检测 为nil表示关闭
 	rabbitmqSendQueueSlices        []Queue.Queue[*rabbitMQ.RabbitMqData]
 	rabbitmqSendQueueSlicesCounter atomic.Uint64
 	rabbitmqSendQueueDone          chan struct{}
 	nacosRespChan                  <-chan bool
+	authSnapshot                   cmap.ConcurrentMap[string, *authSnapshotEntry] // redis不可用时使用的鉴权快照
+	authSnapshotDirty              atomic.Bool
+	authDegraded                   atomic.Bool   // 是否处于降级鉴权模式
+	authDegradedHits               atomic.Uint64 // 降级鉴权成功次数
 }
 
 // Start 启动代理服务的各个组件
 func (m *manager) Start() error {
 	m.nacosConfig = &NacosConfig{}
 	m.initNacosConf()
+	m.initNodeScheduler()
+	m.loadAuthSnapshot()
+	m.loadBlacklist()
 	m.initTcpListener()
 	m.initRabbitmqSendQueueSlices()
 
@@ -71,6 +109,10 @@ func (m *manager) Start() error {
 	// m.tcm.AddTask(1, m.runGrpcServer)
 	m.tcm.AddTask(1, m.runNacosConfServer)
 	m.tcm.AddTask(1, m.runRabbitmqConsume)
+	m.tcm.AddTask(1, m.runUserValidityCheck)
+	m.tcm.AddTask(1, m.runAuthSnapshotPersist)
+	m.tcm.AddTask(1, m.runUserTrafficFlush)
+	m.tcm.AddTask(1, m.runClusterHeartbeat)
 
 	return nil
 }
@@ -100,7 +142,8 @@ func (m *manager) AddIpConnCount(ip string) (ok bool, ipCount int64) {
 				return valueInMap
 			}
 
-			valueInMap = &IpConnCountMapData{}
+			bandwidth := m.getNacosConf().BandwidthLimit
+			valueInMap = &IpConnCountMapData{a: newCapLimitedReaderAction(bandwidth.IpRate, bandwidth.IpBurst)}
 			ipCount = valueInMap.count.Add(1)
 			ok = true
 			return valueInMap
@@ -109,6 +152,14 @@ func (m *manager) AddIpConnCount(ip string) (ok bool, ipCount int64) {
 	return
 }
 
+// getIpLimitedReaderAction 返回出口ip的带宽限速 需在AddIpConnCount成功后调用
+func (m *manager) getIpLimitedReaderAction(ip string) *LimitedReaderAction {
+	if v, ok := m.ipConnCountMap.Get(ip); ok {
+		return v.a
+	}
+	return nil
+}
+
 func (m *manager) ReduceIpConnCount(ip string) {
 	m.ipConnCountMap.RemoveCb(
 		ip,
@@ -122,23 +173,53 @@ func (m *manager) ReduceIpConnCount(ip string) {
 		})
 }
 
-func (m *manager) addUserConnection(k string) *connContext {
+func (m *manager) addUserConnection(k string, authInfo *protobuf.AuthInfo) *connContext {
 	return m.userCtxMap.Upsert(k, nil, func(exist bool, valueInMap *connContext, newValue *connContext) *connContext {
+		conf := m.getNacosConf()
 		if exist {
 			valueInMap.c++
+			valueInMap.authInfo.Store(authInfo)
+			valueInMap.updateLimitedReaderAction(conf.LimitedReader)
 			return valueInMap
 		}
-		conf := m.getNacosConf()
+		limit := conf.LimitedReader.withUser(authInfo)
+		upRate, upBurst := limit.Upload()
+		downRate, downBurst := limit.Download()
 		ctx, cancel := context.WithCancel(m.tcm.Context())
-		return &connContext{
+		connCtx := &connContext{
 			ctx:    ctx,
 			cancel: cancel,
 			c:      1,
-			a:      NewLimitedReaderAction(conf.LimitedReader.ReadRate, conf.LimitedReader.ReadBurst),
+			up:     NewLimitedReaderAction(upRate, upBurst),
+			down:   NewLimitedReaderAction(downRate, downBurst),
 		}
+		connCtx.authInfo.Store(authInfo)
+		return connCtx
 	})
 }
 
+// updateLimitedReaderAction 按全局配置及账号数据更新上下行限速
+func (c *connContext) updateLimitedReaderAction(conf LimitedReaderConf) {
+	limit := conf.withUser(c.authInfo.Load())
+	c.up.UpdateParameter(limit.Upload())
+	c.down.UpdateParameter(limit.Download())
+}
+
+// updateUserConnections 账号数据变更时更新该账号正在使用的连接
+func (m *manager) updateUserConnections(authInfo *protobuf.AuthInfo) {
+	conf := m.getNacosConf()
+	prefix := authInfo.Username + ":"
+	for v := range m.userCtxMap.Iter() {
+		if !strings.HasPrefix(v.Key, prefix) {
+			continue
+		}
+		v.Val.authInfo.Store(authInfo)
+		v.Val.updateLimitedReaderAction(conf.LimitedReader)
+	}
+	m.updateLiveSessionClass(authInfo)
+	m.enforceUserPolicy(authInfo)
+}
+
 func (m *manager) deleteUserConnection(k string, ctx *connContext) {
 	m.userCtxMap.RemoveCb(k, func(key string, valueInMap *connContext, exists bool) bool {
 		if exists {
@@ -167,32 +248,3 @@ func (m *manager) CloseUserConnections(k string) error {
 
 	return fmt.Errorf("CloseUserConnections userCtxMap %s 不存在", k)
 }
-
-func (m *manager) SetBlackMap(bm map[string]struct{}) {
-	m.blackMap.Store(&bm)
-}
-
-func (m *manager) GetBlackMap() *map[string]struct{} {
-	return m.blackMap.Load()
-}
-
-func (m *manager) IsInBlacklist(d string) (string, bool) {
-	bm := m.blackMap.Load()
-	if bm == nil {
-		return "", false
-	}
-	blackMap := *bm
-
-	_, ok := blackMap[d]
-	if ok {
-		return d, true
-	}
-
-	for vv := range blackMap {
-		if strings.Contains(d, vv) {
-			return vv, true
-		}
-	}
-
-	return "", false
-}
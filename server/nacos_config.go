@@ -9,6 +9,7 @@ import (
 
 	"proxy_server/config"
 	"proxy_server/log"
+	"proxy_server/protobuf"
 
 	_ "github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
@@ -16,11 +17,122 @@ import (
 )
 
 type NacosConfig struct {
-	LimitedReader struct {
-		ReadRate  int
-		ReadBurst int
+	LimitedReader  LimitedReaderConf
+	OneIpMaxConn   int
+	BandwidthLimit struct { // 层级带宽限速 字节/秒 0表示不限制 读取需同时满足节点、出口ip、用户、连接各级限制
+		NodeRate  int // 节点总带宽
+		NodeBurst int
+		IpRate    int // 单个出口ip带宽
+		IpBurst   int
+		ConnRate  int // 单个连接带宽
+		ConnBurst int
 	}
-	OneIpMaxConn int
+	UserLimit struct {
+		MaxConn     int    // 单个用户最大并发连接数 0表示不限制 可被账号数据中的max_conn覆盖
+		NewConnRate int    // 单个用户每秒最多新建连接数 0表示不限制 可被账号数据中的new_conn_rate覆盖
+		OverLimit   string // 超过并发连接数时的处理方式 reject拒绝新连接(默认) evict_oldest关闭最早的连接
+	}
+	ServiceClass struct { // 服务等级 节点带宽达到BandwidthLimit.NodeRate时各会话按等级权重分配 未配置NodeRate时不生效
+		Default string         // 账号未设置服务等级时使用 默认standard
+		Weights map[string]int // 服务等级 -> 权重 默认premium:4 standard:2 bulk:1 未知等级按默认等级处理
+	}
+	ClusterLimit struct { // 集群并发连接数限制 各节点通过redis租约共享计数
+		Enable        bool
+		UserMaxConn   int     // 单个用户集群最大并发连接数 0表示不限制
+		IpMaxConn     int     // 单个出口ip集群最大并发连接数 0表示不限制
+		LeaseSeconds  int64   // 租约时长 节点失联超过该时长后其连接数不再计入 0使用默认值 释放的额度最迟1/3租约时长后对其他节点可见
+		FastPathRatio float64 // 已知集群连接数低于上限的该比例时不访问redis 0使用默认值
+	}
+	AuthSnapshot struct {
+		MaxStaleSeconds int64 // redis不可用时快照最长可用时间 0使用默认值 小于0关闭降级鉴权
+	}
+	DestLimit []DestLimitRule // 出口ip到目标域名的连接限制 避免同一出口ip集中访问同一网站被封禁
+	Blacklist struct {
+		Strict         bool // 严格模式 没有可用的黑名单时拒绝所有连接
+		BootstrapRedis bool // 启动时从redis读取全量黑名单
+	}
+	DestGuard struct { // 目标地址检测 解析目标后检测所有地址 防止经代理访问内网
+		Disable   bool     // 关闭检测
+		DenyCIDRs []string // 禁止访问的网段 为空时使用默认的私有网段 本节点地址及redis、rabbitmq地址始终禁止
+	}
+	PortPolicy PortPolicy // 目标端口策略 账号设置了允许端口时覆盖全局策略
+}
+
+// LimitedReaderConf 单个用户上下行限速配置
+// 上行为客户端到目标 下行为目标到客户端 未配置的方向使用ReadRate/ReadBurst
+type LimitedReaderConf struct {
+	ReadRate      int
+	ReadBurst     int
+	UploadRate    int
+	UploadBurst   int
+	DownloadRate  int
+	DownloadBurst int
+}
+
+// Upload 返回上行限速参数
+func (c LimitedReaderConf) Upload() (rate, burst int) {
+	rate, burst = c.UploadRate, c.UploadBurst
+	if rate <= 0 {
+		rate = c.ReadRate
+	}
+	if burst <= 0 {
+		burst = c.ReadBurst
+	}
+	return
+}
+
+// Download 返回下行限速参数
+func (c LimitedReaderConf) Download() (rate, burst int) {
+	rate, burst = c.DownloadRate, c.DownloadBurst
+	if rate <= 0 {
+		rate = c.ReadRate
+	}
+	if burst <= 0 {
+		burst = c.ReadBurst
+	}
+	return
+}
+
+// 默认服务等级权重
+var defaultServiceClassWeights = map[string]int{
+	SERVICE_CLASS_PREMIUM:  4,
+	SERVICE_CLASS_STANDARD: 2,
+	SERVICE_CLASS_BULK:     1,
+}
+
+// serviceClassWeight 返回服务等级对应的调度权重
+func (m *manager) serviceClassWeight(class string) int {
+	conf := m.getNacosConf().ServiceClass
+	weights := conf.Weights
+	if len(weights) == 0 {
+		weights = defaultServiceClassWeights
+	}
+	if weight, ok := weights[class]; ok && weight > 0 {
+		return weight
+	}
+
+	class = conf.Default
+	if class == "" {
+		class = SERVICE_CLASS_STANDARD
+	}
+	if weight, ok := weights[class]; ok && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// withUser 以账号数据中的read_rate、read_burst覆盖全局配置 未设置时保持全局配置
+func (c LimitedReaderConf) withUser(authInfo *protobuf.AuthInfo) LimitedReaderConf {
+	if authInfo == nil {
+		return c
+	}
+	if authInfo.ReadRate > 0 {
+		c.ReadRate, c.UploadRate, c.DownloadRate = int(authInfo.ReadRate), 0, 0
+	}
+	if authInfo.ReadBurst > 0 {
+		c.ReadBurst, c.UploadBurst, c.DownloadBurst = int(authInfo.ReadBurst), 0, 0
+	}
+	return c
 }
 
 func (m *manager) initNacosConf() {
@@ -59,6 +171,7 @@ func (m *manager) initNacosConf() {
 	nacosConfig := &NacosConfig{}
 	m.viperClient.Unmarshal(nacosConfig)
 	m.setNacosConf(nacosConfig)
+	m.updateDestGuard()
 	fmt.Println(nacosConfig)
 }
 
@@ -79,6 +192,7 @@ func (m *manager) runNacosConfServer(ctx context.Context) {
 			if err := m.viperClient.Unmarshal(nacosConfig); err == nil {
 				m.setNacosConf(nacosConfig)
 				m.updateLimitedReaderAction()
+				m.updateDestGuard()
 			}
 			fmt.Println(nacosConfig)
 		case <-ticker.C:
@@ -88,6 +202,7 @@ func (m *manager) runNacosConfServer(ctx context.Context) {
 			if err := m.viperClient.Unmarshal(nacosConfig); err == nil {
 				m.setNacosConf(nacosConfig)
 				m.updateLimitedReaderAction()
+				m.updateDestGuard()
 			}
 			fmt.Println(nacosConfig)
 		}
@@ -110,6 +225,16 @@ func (m *manager) getNacosConf() *NacosConfig {
 func (m *manager) updateLimitedReaderAction() {
 	nacosConfig := m.getNacosConf()
 	for v := range m.userCtxMap.Iter() {
-		v.Val.a.UpdateParameter(nacosConfig.LimitedReader.ReadRate, nacosConfig.LimitedReader.ReadBurst)
+		v.Val.updateLimitedReaderAction(nacosConfig.LimitedReader)
+	}
+
+	bandwidth := nacosConfig.BandwidthLimit
+	m.nodeScheduler.SetLimit(bandwidth.NodeRate, bandwidth.NodeBurst)
+	for v := range m.ipConnCountMap.Iter() {
+		v.Val.a.UpdateParameter(bandwidth.IpRate, bandwidth.IpBurst)
+	}
+	for v := range m.sessionMap.Iter() {
+		v.Val.a.UpdateParameter(bandwidth.ConnRate, bandwidth.ConnBurst)
+		v.Val.flow.SetWeight(m.serviceClassWeight(v.Val.class()))
 	}
 }
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"proxy_server/protobuf"
+)
+
+// PortPolicy 目标端口策略 先检测禁止列表 允许列表不为空时只能访问列表中的端口
+type PortPolicy struct {
+	DenyPorts  []int // 禁止访问的端口 如25、465、587
+	AllowPorts []int // 允许访问的端口 为空表示不限制
+}
+
+func containsPort[T int | int32](ports []T, port int) bool {
+	for _, p := range ports {
+		if int(p) == port {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPortPolicy 检测目标端口 返回拒绝原因
+// 账号的禁止端口优先 账号设置了允许端口时不再检测全局策略
+func (m *manager) checkPortPolicy(authInfo *protobuf.AuthInfo, address string) (string, bool) {
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", true
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", true
+	}
+
+	if authInfo != nil {
+		if containsPort(authInfo.DenyPorts, port) {
+			return fmt.Sprintf("user_deny_port:%d", port), false
+		}
+		if len(authInfo.AllowPorts) > 0 {
+			if containsPort(authInfo.AllowPorts, port) {
+				return "", true
+			}
+			return fmt.Sprintf("user_allow_port:%d", port), false
+		}
+	}
+
+	policy := m.getNacosConf().PortPolicy
+	if containsPort(policy.DenyPorts, port) {
+		return fmt.Sprintf("deny_port:%d", port), false
+	}
+	if len(policy.AllowPorts) > 0 && !containsPort(policy.AllowPorts, port) {
+		return fmt.Sprintf("allow_port:%d", port), false
+	}
+	return "", true
+}
@@ -7,8 +7,10 @@ import (
 
 	"github.com/streadway/amqp"
 	"go.uber.org/zap" // 高性能日志库
+	"google.golang.org/protobuf/proto"
 	"proxy_server/config"
 	"proxy_server/log"
+	"proxy_server/protobuf"
 )
 
 func (m *manager) runRabbitmqBlacklistConsume(ctx context.Context, conn *amqp.Connection) {
@@ -64,7 +66,7 @@ func (m *manager) runRabbitmqBlacklistConsume(ctx context.Context, conn *amqp.Co
 		select {
 		case d, ok := <-msgs:
 			if ok {
-				m.runRabbitmqBlacklistConsumeAction(d.Body)
+				m.runRabbitmqBlacklistConsumeAction(d.ContentType, d.Body)
 			}
 		case <-ctx.Done():
 			return
@@ -76,19 +78,23 @@ func (m *manager) runRabbitmqBlacklistConsume(ctx context.Context, conn *amqp.Co
 	}
 }
 
-func (m *manager) runRabbitmqBlacklistConsumeAction(body []byte) {
-	blacklistMsg := BlacklistBroadcastMsg{}
-	err := json.Unmarshal(body, &blacklistMsg)
-	if err != nil {
-		log.Error("[rabbitmq_blacklist_consume] rabbitmq 黑名单 json.Unmarshal 错误", zap.Error(err))
-		return
+// runRabbitmqBlacklistConsumeAction content_type为application/x-protobuf时按protobuf解析 否则按json解析
+// 规则不合法或版本过期时保留原黑名单
+func (m *manager) runRabbitmqBlacklistConsumeAction(contentType string, body []byte) {
+	blacklistMsg := &protobuf.BlacklistRuleSet{}
+	if contentType == PROTOBUF_CONTENT_TYPE {
+		if err := proto.Unmarshal(body, blacklistMsg); err != nil {
+			log.Error("[rabbitmq_blacklist_consume] rabbitmq 黑名单 proto.Unmarshal 错误", zap.Error(err))
+			return
+		}
+	} else {
+		if err := json.Unmarshal(body, blacklistMsg); err != nil {
+			log.Error("[rabbitmq_blacklist_consume] rabbitmq 黑名单 json.Unmarshal 错误", zap.Error(err))
+			return
+		}
 	}
 
-	blackMapTemp := map[string]struct{}{}
-
-	for _, v := range blacklistMsg.Blacklist {
-		blackMapTemp[v] = struct{}{}
+	if err := m.applyBlacklist(context.Background(), blacklistMsg); err != nil {
+		log.Error("[rabbitmq_blacklist_consume] rabbitmq 黑名单 更新失败", zap.Error(err), zap.Any("list_version", blacklistMsg.ListVersion), zap.Any("delta", blacklistMsg.Delta), zap.Any("ts", blacklistMsg.Ts))
 	}
-
-	m.SetBlackMap(blackMapTemp)
 }
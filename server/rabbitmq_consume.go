@@ -48,6 +48,13 @@ func (m *manager) runRabbitmqConsume(ctx context.Context) {
 		<-timeOutCtx.Done()
 	})
 
+	tcm.AddTask(1, func(ctx context.Context) {
+		m.runRabbitmqSetAuthTokenQueueConsume(ctx, conn)
+		timeOutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+		<-timeOutCtx.Done()
+	})
+
 	tcm.AddTask(1, func(ctx context.Context) {
 		m.runRabbitmqDisconnectQueueConsume(ctx, conn)
 		timeOutCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
@@ -3,7 +3,6 @@ package server
 import (
 	"context"
 	"fmt"
-	"strings"
 
 	"go.uber.org/zap" // 高性能日志库
 	"proxy_server/common"
@@ -91,17 +90,8 @@ func (m *manager) runRabbitmqDeleteUserDataQueueConsumeAction(ctx context.Contex
 		return
 	}
 
-	for v := range m.userCtxMap.Iter() {
-		keys := strings.Split(v.Key, ":")
-		if len(keys) > 0 {
-			// 判断是否包含username
-			if keys[0] == info.Username {
-				v, exist := m.userCtxMap.Pop(v.Key)
-				if exist && v != nil {
-					v.cancel()
-				}
-			}
-		}
-
-	}
+	// 下级账号的连接一并关闭 之后下级账号因上级账号数据不存在而无法通过鉴权
+	m.removeAuthSnapshot(info.Username)
+	m.closeUserAndChildrenConnections(info.Username, nil)
+	m.userPolicyMap.Remove(info.Username)
 }
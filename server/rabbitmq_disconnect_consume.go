@@ -3,7 +3,6 @@ package server
 import (
 	"context"
 	"fmt"
-	"strings"
 
 	"go.uber.org/zap" // 高性能日志库
 
@@ -90,28 +89,6 @@ func (m *manager) runRabbitmqDisconnectQueueConsumeAction(body []byte) {
 		return
 	}
 
-	if len(disConnInfo.Ips) == 0 {
-		for v := range m.userCtxMap.Iter() {
-			keys := strings.Split(v.Key, ":")
-			if len(keys) > 0 {
-				// 判断是否包含username
-				if keys[0] == disConnInfo.Username {
-					v, exist := m.userCtxMap.Pop(v.Key)
-					if exist && v != nil {
-						v.cancel()
-					}
-				}
-			}
-
-		}
-		return
-	}
-
-	for _, ip := range disConnInfo.Ips {
-		key := fmt.Sprintf("%s:%s", disConnInfo.Username, ip)
-		err := m.CloseUserConnections(key)
-		if err != nil {
-			log.Error("[rabbitmq_consume] rabbitmq Disconnect 根据用户ip关闭连接失败", zap.Error(err))
-		}
-	}
+	// 上级账号的断开消息同时作用于全部下级账号
+	m.closeUserAndChildrenConnections(disConnInfo.Username, disConnInfo.Ips)
 }
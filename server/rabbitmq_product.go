@@ -84,12 +84,13 @@ func (m *manager) SendAccessLogMessageToInfluxDB(body []byte) {
 	m.pushRabbitmqSendQueue(data)
 }
 
-func (m *manager) SendBlackListAccessLogMessageData(proxyUserName, proxyServerIpStr, black string, AccountType int32, Account string, ExitIp string) error {
+func (m *manager) SendBlackListAccessLogMessageData(proxyUserName, proxyServerIpStr, black string, AccountType int32, Account string, ExitIp string, monitor bool) error {
 	blackListAccessLog := &protobuf.BlackListAccessLog{
 		Site:        black,
 		AccountType: AccountType,
 		Account:     proxyUserName,
 		ExitIp:      proxyServerIpStr,
+		Monitor:     monitor,
 	}
 
 	sendByte, err := proto.Marshal(blackListAccessLog)
@@ -107,12 +108,38 @@ func (m *manager) SendBlackListAccessLogMessage(body []byte) {
 	m.pushRabbitmqSendQueue(data)
 }
 
-func (m *manager) ReportAccessLogToInfluxDB(user, domain, ip string) {
+func (m *manager) SendQuotaExhaustedMessageData(username, period string, used, quota int64) error {
+	event := &protobuf.QuotaExhaustedEvent{
+		Username:   username,
+		Period:     period,
+		UsedBytes:  used,
+		QuotaBytes: quota,
+		Ts:         time.Now().Unix(),
+	}
+
+	sendByte, err := proto.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("序列化配额耗尽事件失败%w", err)
+	}
+
+	unique := time.Now().String() + util.RandStringBytesMaskImprSrcSB(8)
+	data := rabbitMQ.GetRabbitMqDataFormat("", "", config.GetConf().Rabbitmq.QuotaExhaustedQueue, "", sendByte, unique)
+	m.pushRabbitmqSendQueue(data)
+	return nil
+}
+
+func (m *manager) ReportAccessLogToInfluxDB(user, domain, ip, clientAddr string, stats *sessionStats) {
 	accessLog := protobuf.AccessRecordsToInfluxDB{
-		UserName:  user,
-		Domain:    domain,
-		Ip:        ip,
-		ProxyType: "ipv4",
+		UserName:      user,
+		Domain:        domain,
+		Ip:            ip,
+		ProxyType:     "ipv4",
+		ClientAddr:    clientAddr,
+		UploadBytes:   stats.upload.Load(),
+		DownloadBytes: stats.download.Load(),
+		StartUnixMs:   stats.start.UnixMilli(),
+		EndUnixMs:     time.Now().UnixMilli(),
+		CloseReason:   stats.closeReason,
 	}
 	sendBytes, _ := proto.Marshal(&accessLog)
 	m.SendAccessLogMessageToInfluxDB(sendBytes)
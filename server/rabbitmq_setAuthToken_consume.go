@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.uber.org/zap" // 高性能日志库
+	"google.golang.org/protobuf/proto"
+	"proxy_server/common"
+	"proxy_server/config"
+	"proxy_server/log"
+	"proxy_server/protobuf"
+)
+
+// /签发及吊销令牌
+func (m *manager) runRabbitmqSetAuthTokenQueueConsume(ctx context.Context, conn *amqp.Connection) {
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Error("[rabbitmq_consume] rabbitmq SetAuthToken conn.Channel 错误", zap.Error(err))
+		return
+	}
+	defer ch.Close()
+
+	qName := fmt.Sprintf("SetAuthToken_%s_%s", config.GetConf().LocalIp, config.GetConf().ProcessName)
+	// 声明一个队列
+	if _, err := ch.QueueDeclare(
+		qName, // 队列名称
+		true,  // 是否持久化
+		false, // 是否自动删除
+		false, // 是否排他
+		false, // 是否等待服务器响应
+		nil,   // 额外参数
+	); err != nil {
+		log.Error("[rabbitmq_consume] rabbitmq SetAuthToken ch.QueueDeclare 错误", zap.Error(err))
+		return
+	}
+
+	// 从队列中消费消息
+	msgs, err := ch.Consume(
+		qName, // 队列名称
+		"",    // 消费者名称
+		false, // 是否自动确认
+		false, // 是否排他
+		false, // 是否为本地队列
+		false, // 是否等待服务器响应
+		nil,   // 额外参数
+	)
+	if err != nil {
+		log.Error("[rabbitmq_consume] rabbitmq SetAuthToken ch.Consume 错误", zap.Error(err))
+		return
+	}
+
+	closeChan := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	for {
+		select {
+		case d, ok := <-msgs:
+			if ok {
+				m.runRabbitmqSetAuthTokenQueueConsumeAction(ctx, &d)
+			}
+		case <-ctx.Done():
+			return
+		case <-closeChan:
+			log.Error("[rabbitmq_consume] rabbitmq SetAuthToken 信道关闭")
+			return
+
+		}
+	}
+}
+
+func (m *manager) runRabbitmqSetAuthTokenQueueConsumeAction(ctx context.Context, d *amqp.Delivery) {
+	authToken := &protobuf.AuthToken{}
+	err := proto.Unmarshal(d.Body, authToken)
+	if err != nil {
+		d.Nack(false, false)
+		log.Error("[rabbitmq_consume] rabbitmq SetAuthToken proto.Unmarshal 错误", zap.Error(err))
+		return
+	}
+
+	if authToken.Token == "" || (!authToken.Revoke && authToken.Username == "") {
+		d.Nack(false, false)
+		log.Error("[rabbitmq_consume] rabbitmq SetAuthToken 令牌或账号为空", zap.Any("user", authToken.Username))
+		return
+	}
+
+	tokenKey := fmt.Sprintf("%s_%s", REDIS_AUTH_TOKEN, authToken.Token)
+
+	// 吊销或已过期的令牌直接删除
+	expiration := time.Duration(0)
+	if authToken.ExpireUnix != 0 {
+		expiration = time.Until(time.Unix(authToken.ExpireUnix, 0))
+	}
+	if authToken.Revoke || expiration < 0 {
+		if err := common.GetRedisDB().Del(context.Background(), tokenKey).Err(); err != nil {
+			log.Error("[rabbitmq_consume] rabbitmq SetAuthToken 删除令牌失败", zap.Error(err))
+			d.Nack(false, true)
+			return
+		}
+		d.Ack(false)
+		log.Info("[rabbitmq_consume] rabbitmq SetAuthToken 吊销成功", zap.Any("user", authToken.Username))
+		return
+	}
+
+	authToken.Revoke = false
+	authToken.UpdateUnix = time.Now().Unix()
+	data, err := json.Marshal(authToken)
+	if err != nil {
+		d.Nack(false, false)
+		log.Error("[rabbitmq_consume] rabbitmq SetAuthToken json.Marshal 错误", zap.Error(err))
+		return
+	}
+
+	if err := common.GetRedisDB().Set(context.Background(), tokenKey, string(data), expiration).Err(); err != nil {
+		log.Error("[rabbitmq_consume] rabbitmq SetAuthToken 保存令牌失败", zap.Error(err))
+		d.Nack(false, true)
+		return
+	}
+	d.Ack(false)
+	log.Info("[rabbitmq_consume] rabbitmq SetAuthToken 成功", zap.Any("user", authToken.Username))
+}
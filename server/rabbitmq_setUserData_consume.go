@@ -111,5 +111,7 @@ func (m *manager) runRabbitmqSetUserDataQueueConsumeAction(ctx context.Context,
 		return
 	}
 	d.Ack(false)
+	m.replaceAuthSnapshot(info, setMembers)
+	m.updateUserConnections(info)
 	log.Info("[rabbitmq_consume] rabbitmq SetUserData 成功", zap.Any("user", info.Username))
 }
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"proxy_server/utils/socks5"
+)
+
+// 拒绝原因 HTTP代理以X-Proxy-Error头返回 SOCKS代理映射为对应的应答码
+const (
+	REJECT_BLACKLISTED      = "blacklisted"        // 命中全局黑名单、账号访问策略或禁止访问的网段
+	REJECT_PORT_DENIED      = "port_denied"        // 目标端口被禁止
+	REJECT_QUOTA_EXCEEDED   = "quota_exceeded"     // 流量配额已用完
+	REJECT_LIMIT_REACHED    = "limit_reached"      // 连接数或新建连接速率达到上限
+	REJECT_DEST_LIMITED     = "dest_limit_reached" // 出口ip到目标域名的连接数或新建连接速率达到上限
+	REJECT_AUTH_FAILED      = "auth_failed"        // 鉴权失败
+	REJECT_UPSTREAM_FAILURE = "upstream_failure"   // 目标连接失败
+	REJECT_UNAVAILABLE      = "unavailable"        // 服务暂不可用 如严格模式下黑名单未加载
+)
+
+// rejectResponse 拒绝原因对应的应答
+type rejectResponse struct {
+	status     int   // http状态码
+	socksReply uint8 // socks5应答码 鉴权失败在子协商阶段以AuthFailure应答 不使用该值
+}
+
+var rejectResponses = map[string]rejectResponse{
+	REJECT_BLACKLISTED:      {http.StatusForbidden, socks5.RuleFailure},
+	REJECT_PORT_DENIED:      {http.StatusForbidden, socks5.RuleFailure},
+	REJECT_QUOTA_EXCEEDED:   {http.StatusTooManyRequests, socks5.RuleFailure},
+	REJECT_LIMIT_REACHED:    {http.StatusTooManyRequests, socks5.ConnectionRefused},
+	REJECT_DEST_LIMITED:     {http.StatusTooManyRequests, socks5.RuleFailure},
+	REJECT_AUTH_FAILED:      {http.StatusProxyAuthRequired, socks5.RuleFailure},
+	REJECT_UPSTREAM_FAILURE: {http.StatusBadGateway, socks5.HostUnreachable},
+	REJECT_UNAVAILABLE:      {http.StatusServiceUnavailable, socks5.ServerFailure},
+}
+
+// writeHttpReject 返回拒绝应答 header为附加的响应头 如Proxy-Authenticate
+func writeHttpReject(conn net.Conn, reason string, header ...string) error {
+	resp := rejectResponses[reason]
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\nX-Proxy-Error: %s\r\n", resp.status, http.StatusText(resp.status), reason)
+	for _, h := range header {
+		b.WriteString(h)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	_, err := conn.Write([]byte(b.String()))
+	return err
+}
+
+// sendSocksReject 返回拒绝应答
+func sendSocksReject(conn net.Conn, reason string) error {
+	return socks5.SendReply(conn, rejectResponses[reason].socksReply, nil)
+}
+
+// sendSocksUpstreamReject 目标连接失败时按错误细分应答码
+func sendSocksUpstreamReject(conn net.Conn, err error) error {
+	resp := rejectResponses[REJECT_UPSTREAM_FAILURE].socksReply
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		resp = socks5.ConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		resp = socks5.NetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		resp = socks5.HostUnreachable
+	}
+	return socks5.SendReply(conn, resp, nil)
+}
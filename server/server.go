@@ -7,3 +7,19 @@ func Start() error {
 func Stop() {
 	newManager().Stop()
 }
+
+// AuthDegradedStats 返回是否处于降级鉴权模式及降级鉴权成功次数
+func AuthDegradedStats() (bool, uint64) {
+	m := newManager()
+	return m.authDegraded.Load(), m.authDegradedHits.Load()
+}
+
+// DestRejectStats 返回目标域名限制的拒绝总次数及各规则域名的拒绝次数
+func DestRejectStats() (uint64, map[string]uint64) {
+	return newManager().destRejectStats()
+}
+
+// BlacklistMonitorStats 返回观察模式各规则的命中次数
+func BlacklistMonitorStats() map[string]uint64 {
+	return newManager().blacklistMonitorStats()
+}
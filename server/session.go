@@ -0,0 +1,105 @@
+package server
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"proxy_server/protobuf"
+	"proxy_server/utils/rateLimit"
+)
+
+// 会话关闭原因
+const (
+	CLOSE_REASON_EOF       = "eof"       // 任一方正常关闭
+	CLOSE_REASON_ERROR     = "error"     // 读写出错
+	CLOSE_REASON_BLACKLIST = "blacklist" // 命中黑名单
+	CLOSE_REASON_KICKED    = "kicked"    // 账号连接被关闭 断开消息、账号失效、配额耗尽等
+	CLOSE_REASON_SHUTDOWN  = "shutdown"  // 服务停止
+	CLOSE_REASON_EVICTED   = "evicted"   // 账号连接数超限 被新连接挤掉
+	CLOSE_REASON_PORT      = "port"      // 目标端口被禁止 未建立连接
+)
+
+// 服务等级
+const (
+	SERVICE_CLASS_PREMIUM  = "premium"
+	SERVICE_CLASS_STANDARD = "standard"
+	SERVICE_CLASS_BULK     = "bulk"
+)
+
+// sessionStats 单个会话的流量及时长统计 会话结束时随访问记录上报
+type sessionStats struct {
+	start       time.Time
+	upload      atomic.Int64 // 上行字节 客户端->目标
+	download    atomic.Int64 // 下行字节 目标->客户端
+	closeReason string
+}
+
+func newSessionStats(start time.Time) *sessionStats {
+	return &sessionStats{start: start, closeReason: CLOSE_REASON_ERROR}
+}
+
+// liveSession 正在转发的会话 用于热更新连接级配置及立即执行黑名单
+type liveSession struct {
+	username     string
+	serviceClass atomic.Value         // 账号服务等级 string
+	a            *LimitedReaderAction // 连接级带宽限速 上下行共用
+	flow         *rateLimit.Flow      // 节点带宽调度 权重由账号服务等级决定
+	target       *sessionTarget
+	id           string
+	host         string // 索引中的目标 只在会话所在的协程中修改
+}
+
+// sessionSet 同一目标的会话 写时复制 读取时无需加锁
+type sessionSet map[string]*liveSession
+
+// addLiveSession 登记会话 登记后立即检测一次目标 避免错过检测与登记之间的黑名单更新
+func (m *manager) addLiveSession(id uint64, authInfo *protobuf.AuthInfo, target *sessionTarget) *liveSession {
+	bandwidth := m.getNacosConf().BandwidthLimit
+	session := &liveSession{
+		id:       strconv.FormatUint(id, 10),
+		username: authInfo.Username,
+		a:        newCapLimitedReaderAction(bandwidth.ConnRate, bandwidth.ConnBurst),
+		flow:     m.nodeScheduler.NewFlow(m.serviceClassWeight(authInfo.ServiceClass)),
+		target:   target,
+	}
+	session.serviceClass.Store(authInfo.ServiceClass)
+	m.sessionMap.Set(session.id, session)
+	m.checkLiveSession(session)
+	return session
+}
+
+func (s *liveSession) class() string {
+	class, _ := s.serviceClass.Load().(string)
+	return class
+}
+
+func (m *manager) deleteLiveSession(session *liveSession) {
+	m.sessionMap.Remove(session.id)
+	m.unindexLiveSession(session)
+}
+
+func (m *manager) initNodeScheduler() {
+	bandwidth := m.getNacosConf().BandwidthLimit
+	m.nodeScheduler = rateLimit.NewScheduler(bandwidth.NodeRate, bandwidth.NodeBurst)
+}
+
+// updateLiveSessionClass 账号服务等级变更时更新其会话的调度权重
+func (m *manager) updateLiveSessionClass(authInfo *protobuf.AuthInfo) {
+	weight := m.serviceClassWeight(authInfo.ServiceClass)
+	for v := range m.sessionMap.Iter() {
+		if v.Val.username == authInfo.Username {
+			v.Val.serviceClass.Store(authInfo.ServiceClass)
+			v.Val.flow.SetWeight(weight)
+		}
+	}
+}
+
+// bandwidthActions 按连接、用户、账号、出口ip、节点的顺序组合各级限速
+// 上级限速由下属连接共享 令牌按预约先后分配 空闲额度会被仍在读取的连接使用
+// 节点级按服务等级加权公平调度 带宽紧张时高等级会话获得更多额度
+func (m *manager) bandwidthActions(session *liveSession, userAction *LimitedReaderAction, accountActions []*LimitedReaderAction, ipAction *LimitedReaderAction) []*LimitedReaderAction {
+	actions := []*LimitedReaderAction{session.a, userAction}
+	actions = append(actions, accountActions...)
+	return append(actions, ipAction, newFlowLimitedReaderAction(session.flow))
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"proxy_server/protobuf"
+	"proxy_server/utils/blacklistRule"
+)
+
+// sessionTarget 会话的访问目标 黑名单或账号访问策略变更时立即检测
+type sessionTarget struct {
+	address  string                             // 请求的目标地址 host:port
+	domain   *atomic.Pointer[string]            // 域名 以ip访问时由流量识别
+	protocol *atomic.Pointer[string]            // 协议 由流量识别
+	authInfo *atomic.Pointer[protobuf.AuthInfo] // 账号数据 SetUserData时更新
+	onBlock  func(rule string, global bool)     // 命中时关闭会话 global表示命中全局黑名单
+	once     sync.Once
+
+	onMonitor func(rule string) // 命中观察模式的规则时上报 不关闭会话
+	monitored string            // 已上报的观察模式规则 受monitorMu保护
+	monitorMu sync.Mutex
+}
+
+func (t *sessionTarget) current() blacklistRule.Target {
+	return blacklistTarget(derefString(t.domain.Load()), t.address, derefString(t.protocol.Load()))
+}
+
+// block 关闭会话 只执行一次
+func (t *sessionTarget) block(rule string, global bool) {
+	t.once.Do(func() {
+		t.onBlock(rule, global)
+	})
+}
+
+// indexLiveSession 按当前目标登记到索引 目标由流量识别出域名后改变
+func (m *manager) indexLiveSession(session *liveSession, host string) {
+	if session.host == host {
+		return
+	}
+	m.unindexLiveSession(session)
+	session.host = host
+	m.sessionHostMap.Upsert(host, nil, func(exist bool, valueInMap sessionSet, newValue sessionSet) sessionSet {
+		set := make(sessionSet, len(valueInMap)+1)
+		for id, s := range valueInMap {
+			set[id] = s
+		}
+		set[session.id] = session
+		return set
+	})
+}
+
+func (m *manager) unindexLiveSession(session *liveSession) {
+	if session.host == "" {
+		return
+	}
+	m.sessionHostMap.Upsert(session.host, nil, func(exist bool, valueInMap sessionSet, newValue sessionSet) sessionSet {
+		set := make(sessionSet, len(valueInMap))
+		for id, s := range valueInMap {
+			if id != session.id {
+				set[id] = s
+			}
+		}
+		return set
+	})
+	m.sessionHostMap.RemoveCb(session.host, func(key string, valueInMap sessionSet, exists bool) bool {
+		return exists && len(valueInMap) == 0
+	})
+	session.host = ""
+}
+
+// monitorLiveSession 上报会话命中的观察模式规则 同一规则每个会话只上报一次
+func (m *manager) monitorLiveSession(session *liveSession, rule string) {
+	t := session.target
+	t.monitorMu.Lock()
+	if t.monitored == rule {
+		t.monitorMu.Unlock()
+		return
+	}
+	t.monitored = rule
+	t.monitorMu.Unlock()
+
+	m.countBlacklistMonitor(rule)
+	t.onMonitor(rule)
+}
+
+// checkLiveSession 更新索引并检测会话当前目标 命中全局黑名单或账号访问策略时关闭会话
+func (m *manager) checkLiveSession(session *liveSession) {
+	target := session.target.current()
+	m.indexLiveSession(session, target.Host)
+	if black, in := m.matchBlacklist(target); in {
+		session.target.block(black, true)
+		return
+	}
+	if reason, ok := m.checkUserPolicy(session.target.authInfo.Load(), target); !ok {
+		session.target.block(reason, false)
+		return
+	}
+	// 识别出域名或协议后可能命中新的规则
+	if rule, in := m.matchBlacklistMonitor(target); in {
+		m.monitorLiveSession(session, rule)
+	}
+}
+
+// enforceBlacklist 黑名单更新后按目标检测会话 立即关闭命中的会话
+// 未被关闭的会话检测观察模式的规则 与规则真正生效时关闭的会话一致
+// 同一目标下端口及协议相同的会话只匹配一次
+func (m *manager) enforceBlacklist() {
+	type result struct {
+		black   string
+		in      bool
+		monitor string
+		watched bool
+	}
+	for v := range m.sessionHostMap.Iter() {
+		results := map[blacklistRule.Target]result{}
+		for _, session := range v.Val {
+			target := session.target.current()
+			r, ok := results[target]
+			if !ok {
+				r.black, r.in = m.matchBlacklist(target)
+				if !r.in {
+					r.monitor, r.watched = m.matchBlacklistMonitor(target)
+				}
+				results[target] = r
+			}
+			if r.in {
+				session.target.block(r.black, true)
+			} else if r.watched {
+				m.monitorLiveSession(session, r.monitor)
+			}
+		}
+	}
+}
+
+// enforceUserPolicy 账号访问策略变更后立即检测该账号的会话
+func (m *manager) enforceUserPolicy(authInfo *protobuf.AuthInfo) {
+	for v := range m.sessionMap.Iter() {
+		if v.Val.username != authInfo.Username {
+			continue
+		}
+		if reason, ok := m.checkUserPolicy(authInfo, v.Val.target.current()); !ok {
+			v.Val.target.block(reason, false)
+		}
+	}
+}
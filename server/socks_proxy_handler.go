@@ -18,10 +18,12 @@ import (
 	"proxy_server/log"
 	"proxy_server/server/sniffing"
 	"proxy_server/server/sniffing/tls"
+	"proxy_server/utils/blacklistRule"
 	"proxy_server/utils/socks5"
 )
 
 func (m *manager) socksTcpConn(ctx context.Context, conn net.Conn) {
+	stats := newSessionStats(time.Now())
 	// 读取账号密码
 	var user, pwd string
 	user, pwd, err := socks5.GetUserPassword(conn)
@@ -36,79 +38,147 @@ func (m *manager) socksTcpConn(ctx context.Context, conn net.Conn) {
 	proxyServerConn := conn.LocalAddr().(*net.TCPAddr)
 	proxyServerIpStr := proxyServerConn.IP.String()
 	proxyServerIpByte := proxyServerConn.IP.To4()
+	clientIpStr := conn.RemoteAddr().(*net.TCPAddr).IP.String()
 
-	_, err = m.Valid(ctx, user, pwd, proxyServerIpStr)
+	authInfo, err := m.Valid(ctx, user, pwd, proxyServerIpStr, clientIpStr)
 	if err != nil {
-		log.Error("[socks_proxy_handler] 鉴权失败", zap.Error(err), zap.Any("user", user), zap.Any("pwd", pwd), zap.Any("ip", proxyServerIpStr))
+		log.Error("[socks_proxy_handler] 鉴权失败", zap.Error(err), zap.Any("user", user), zap.Any("ip", proxyServerIpStr), zap.Any("client_ip", clientIpStr))
 		if _, err = conn.Write([]byte{socks5.UserAuthVersion, socks5.AuthFailure}); err != nil {
 			return
 		}
 		return
 	}
 
+	///认证成功，返回消息给客户端 之后的拒绝以请求应答码返回
+	if _, err = conn.Write([]byte{socks5.UserAuthVersion, socks5.AuthSuccess}); err != nil {
+		log.Error("[socks_proxy_handler] 认证成功，返回消息给客户端失败", zap.Any("ip", proxyServerIpStr), zap.Any("user", user))
+		return
+	}
+
+	var destAddr *socks5.AddrSpec
+	destAddr, err = socks5.ReadDestAddr(conn)
+	if err != nil {
+		if err == socks5.UnrecognizedAddrType {
+			if err = socks5.SendReply(conn, socks5.AddrTypeNotSupported, nil); err != nil {
+				return
+			}
+		}
+		return
+	}
+
 	if ok, ipCount := m.AddIpConnCount(proxyServerIpStr); ok {
 		defer m.ReduceIpConnCount(proxyServerIpStr)
 	} else {
 		// ip的连接数到达上限
 		log.Error("[socks_proxy_handler] ip连接数达到上限", zap.Any("ip", proxyServerIpStr), zap.Any("连接数", ipCount), zap.Any("user", user))
-		resp := socks5.ConnectionRefused
-		if err = socks5.SendReply(conn, resp, nil); err != nil {
+		if err = sendSocksReject(conn, REJECT_LIMIT_REACHED); err != nil {
 			return
 		}
 		return
 
 	}
 
-	///认证成功，返回消息给客户端
-	if _, err = conn.Write([]byte{socks5.UserAuthVersion, socks5.AuthSuccess}); err != nil {
-		log.Error("[socks_proxy_handler] 认证成功，返回消息给客户端失败", zap.Any("ip", proxyServerIpStr), zap.Any("user", user))
+	if err = m.checkUserQuota(ctx, authInfo); err != nil {
+		log.Error("[socks_proxy_handler] 流量配额已用完", zap.Error(err), zap.Any("user", user))
+		if err = sendSocksReject(conn, REJECT_QUOTA_EXCEEDED); err != nil {
+			return
+		}
 		return
 	}
 
-	var destAddr *socks5.AddrSpec
-	destAddr, err = socks5.ReadDestAddr(conn)
-	if err != nil {
-		if err == socks5.UnrecognizedAddrType {
-			if err = socks5.SendReply(conn, socks5.AddrTypeNotSupported, nil); err != nil {
-				return
-			}
+	domain := regexpDomain(destAddr.Address())
+	// 协议在转发时识别
+	var protocolPointer atomic.Pointer[string]
+	if m.blacklistUnavailable() {
+		log.Error("[socks_proxy_handler] 严格模式下黑名单未加载 拒绝连接", zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", destAddr.Address()), zap.Any("user", user))
+		if err = sendSocksReject(conn, REJECT_UNAVAILABLE); err != nil {
+			return
+		}
+		return
+	}
+	blackTarget := blacklistTarget(domain, destAddr.Address(), "")
+	if black, in := m.matchBlacklist(blackTarget); in {
+		m.SendBlackListAccessLogMessageData(user, proxyServerIpStr, black, 1, user, proxyServerIpStr, false)
+		log.Error("[socks_proxy_handler] 黑名单", zap.Any("domain", domain), zap.Any("rule", black), zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", destAddr.Address()), zap.Any("user", user))
+		if err = sendSocksReject(conn, REJECT_BLACKLISTED); err != nil {
+			return
+		}
+		return
+	}
+	if reason, ok := m.checkUserPolicy(authInfo, blackTarget); !ok {
+		log.Error("[socks_proxy_handler] 账号访问策略拒绝", zap.Any("reason", reason), zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", destAddr.Address()), zap.Any("user", user))
+		if err = sendSocksReject(conn, REJECT_BLACKLISTED); err != nil {
+			return
+		}
+		return
+	}
+	if reason, ok := m.checkPortPolicy(authInfo, destAddr.Address()); !ok {
+		stats.closeReason = CLOSE_REASON_PORT
+		m.ReportAccessLogToInfluxDB(user, destAddr.Address(), proxyServerConn.String(), conn.RemoteAddr().String(), stats)
+		log.Error("[socks_proxy_handler] 目标端口禁止访问", zap.Any("reason", reason), zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", destAddr.Address()), zap.Any("user", user))
+		if err = sendSocksReject(conn, REJECT_PORT_DENIED); err != nil {
+			return
 		}
 		return
 	}
 
-	domain := regexpDomain(destAddr.Address())
 	if domain != "" {
-		if black, in := m.IsInBlacklist(domain); in {
-			m.SendBlackListAccessLogMessageData(user, pwd, black, 1, user, proxyServerIpStr)
-			log.Error("[socks_proxy_handler] 黑名单", zap.Any("domain", domain), zap.Any("local_ip", proxyServerIpStr), zap.Any("target_addr", destAddr.Address()), zap.Any("user", user))
-			if err = socks5.SendReply(conn, socks5.HostUnreachable, nil); err != nil {
+		destConn, err := m.addDestConnection(proxyServerIpStr, domain)
+		if err != nil {
+			log.Error("[socks_proxy_handler] 目标域名连接数达到上限", zap.Error(err), zap.Any("ip", proxyServerIpStr), zap.Any("user", user))
+			if err = sendSocksReject(conn, destLimitReason(err)); err != nil {
 				return
 			}
+			return
 		}
-		return
+		defer m.deleteDestConnection(destConn)
 	}
 
 	var domainPointer atomic.Pointer[string]
 	domainPointer.Store(&domain)
 
 	var target net.Conn
-	target, err = DialContext(ctx, "tcp", destAddr.Address(), time.Second*10, proxyServerIpByte, 0)
+	target, err = m.dialDest(ctx, destAddr.Address(), time.Second*10, proxyServerIpByte)
+	if denied, ok := asDestDenied(err); ok {
+		m.SendBlackListAccessLogMessageData(user, proxyServerIpStr, denied.rule(), 1, user, proxyServerIpStr, false)
+		log.Error("[socks_proxy_handler] 目标地址禁止访问", zap.Error(err), zap.Any("local_ip", proxyServerIpStr), zap.Any("destAddr", destAddr.Address()), zap.Any("user", user))
+		if err = sendSocksReject(conn, REJECT_BLACKLISTED); err != nil {
+			return
+		}
+		return
+	}
 	if err != nil {
 		log.Error("[socks_proxy_handler] DialContext 创建目标连接失败", zap.Error(err))
-		msg := err.Error()
-		resp := socks5.HostUnreachable
-		if strings.Contains(msg, "refused") {
-			resp = socks5.ConnectionRefused
-		} else if strings.Contains(msg, "network is unreachable") {
-			resp = socks5.NetworkUnreachable
-		}
-		if err = socks5.SendReply(conn, resp, nil); err != nil {
+		if err = sendSocksUpstreamReject(conn, err); err != nil {
 			return
 		}
 		return
 	}
 	defer target.Close()
 
+	// 通过全部检测并连接目标成功后再占用账号及集群额度 避免被拒绝的请求挤掉账号已有的连接
+	sessionCtx, sessionCancel := context.WithCancel(ctx)
+	defer sessionCancel()
+	accountConn, err := m.addAccountConnection(ctx, authInfo, sessionCancel)
+	if err != nil {
+		log.Error("[socks_proxy_handler] 账号连接数达到上限", zap.Error(err), zap.Any("ip", proxyServerIpStr), zap.Any("user", user))
+		if err = sendSocksReject(conn, REJECT_LIMIT_REACHED); err != nil {
+			return
+		}
+		return
+	}
+	defer m.deleteAccountConnection(accountConn)
+
+	clusterConn, err := m.addClusterConnection(ctx, user, proxyServerIpStr)
+	if err != nil {
+		log.Error("[socks_proxy_handler] 集群连接数达到上限", zap.Error(err), zap.Any("ip", proxyServerIpStr), zap.Any("user", user))
+		if err = sendSocksReject(conn, REJECT_LIMIT_REACHED); err != nil {
+			return
+		}
+		return
+	}
+	defer m.deleteClusterConnection(clusterConn)
+
 	clientAddr := conn.RemoteAddr().String()
 	log.Info("[socks_proxy_handler] 创建目标连接成功 ",
 		zap.Any("username", user),
@@ -126,9 +196,40 @@ func (m *manager) socksTcpConn(ctx context.Context, conn net.Conn) {
 	}
 
 	key := fmt.Sprintf("%s:%s", user, proxyServerIpStr)
-	connCtx := m.addUserConnection(key)
-	action := connCtx.a
+	connCtx := m.addUserConnection(key, authInfo)
+	blockCtx, blockCancel := context.WithCancel(ctx)
+	defer blockCancel()
+	session := m.addLiveSession(accountConn.id, authInfo, &sessionTarget{
+		address:  destAddr.Address(),
+		domain:   &domainPointer,
+		protocol: &protocolPointer,
+		authInfo: &connCtx.authInfo,
+		onBlock: func(rule string, global bool) {
+			if global {
+				m.SendBlackListAccessLogMessageData(user, proxyServerIpStr, rule, 1, user, proxyServerIpStr, false)
+			}
+			log.Error("[socks_proxy_handler] 黑名单 关闭连接",
+				zap.Any("domain", domainPointer.Load()),
+				zap.Any("rule", rule),
+				zap.Any("global", global),
+				zap.Any("username", user),
+				zap.Any("local_ip", proxyServerIpStr),
+				zap.Any("target_host", destAddr.Address()),
+			)
+			blockCancel()
+		},
+		onMonitor: func(rule string) {
+			m.SendBlackListAccessLogMessageData(user, proxyServerIpStr, rule, 1, user, proxyServerIpStr, true)
+			log.Info("[socks_proxy_handler] 黑名单观察模式命中 不拦截", zap.Any("rule", rule), zap.Any("username", user), zap.Any("target_host", destAddr.Address()))
+		},
+	})
+	defer m.deleteLiveSession(session)
+	ipAction := m.getIpLimitedReaderAction(proxyServerIpStr)
+	upActions := m.bandwidthActions(session, connCtx.up, accountConn.actions, ipAction)
+	downActions := m.bandwidthActions(session, connCtx.down, accountConn.actions, ipAction)
 	defer m.deleteUserConnection(key, connCtx)
+	traffic := m.addUserTraffic(user, authInfo)
+	defer m.deleteUserTraffic(user, traffic)
 
 	netConn := newConn(conn, CONN_WRITE_TIME, CONN_READ_TIME)
 	netTarget := newConn(target, CONN_WRITE_TIME, CONN_READ_TIME)
@@ -139,126 +240,127 @@ func (m *manager) socksTcpConn(ctx context.Context, conn net.Conn) {
 	errCh := make(chan error, 2)
 	defer close(errCh)
 
+	// 转发读取使用会话自己的ctx connCtx.ctx由同一用户出口ip的会话共享 会话结束时不会取消
+	relayCtx, relayCancel := context.WithCancel(connCtx.ctx)
 	done := make(chan struct{})
 	wg := sync.WaitGroup{}
 	defer func() {
+		// 先取消正在等待限速额度的读取 避免等完全部排队额度才结束 未使用的额度退回
+		relayCancel()
 		close(done)
 		netConn.Close()
 		netTarget.Close()
-		domainPointer.Load()
+		// 等待转发结束后再上报 保证统计的字节数完整
+		wg.Wait()
 
 		domain := domainPointer.Load()
 		if domain != nil && *domain != "" {
-			m.ReportAccessLogToInfluxDB(user, *domain, proxyServerConn.String())
+			m.ReportAccessLogToInfluxDB(user, *domain, proxyServerConn.String(), clientAddr, stats)
 		} else {
 			hostArr := strings.Split(destAddr.Address(), ":")
 			if cap(hostArr) > 0 {
-				m.ReportAccessLogToInfluxDB(user, hostArr[0], proxyServerConn.String())
+				m.ReportAccessLogToInfluxDB(user, hostArr[0], proxyServerConn.String(), clientAddr, stats)
 			} else {
-				m.ReportAccessLogToInfluxDB(user, destAddr.Address(), proxyServerConn.String())
+				m.ReportAccessLogToInfluxDB(user, destAddr.Address(), proxyServerConn.String(), clientAddr, stats)
 			}
 		}
-
-		wg.Wait()
 	}()
 
-	///域名为空，并且使用CONNECT
-	if domain == "" {
-		readWriterNotice, err := sniffing.NewReadWriterNotice(
-			netConn,
-			nil,
-			func(buf []byte) {
-				byteChan <- buf
-			})
-		if err != nil {
+	///识别协议 域名为空时同时识别域名
+	readWriterNotice, err := sniffing.NewReadWriterNotice(
+		netConn,
+		nil,
+		func(buf []byte) {
+			byteChan <- buf
+		})
+	if err != nil {
+		return
+	}
+	netConn = readWriterNotice
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// 识别出域名或协议后立即检测
+		defer m.checkLiveSession(session)
+		select {
+		case <-done:
 			return
-		}
-		netConn = readWriterNotice
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			select {
-			case <-done:
-				return
-			case buf, ok := <-byteChan:
-				if len(buf) > 0 && ok {
-
-					// 如果数据的第一个字节是 0x16，可能是 TLS 握手的 ClientHello 消息
-					if buf[0] == 0x16 {
-						// 创建一个 ClientHelloMsg 实例
-						clientHelloMsg := tls.ClientHelloMsg{}
-						// 尝试将负载数据反序列化为 ClientHelloMsg 实例
-						clientHelloMsg.UnmarshalByByte(buf)
-						// 如果反序列化后得到了 ServerName
-						if clientHelloMsg.ServerName != "" {
+		case buf, ok := <-byteChan:
+			if len(buf) > 0 && ok {
+
+				// 如果数据的第一个字节是 0x16，可能是 TLS 握手的 ClientHello 消息
+				if buf[0] == 0x16 {
+					// 创建一个 ClientHelloMsg 实例
+					clientHelloMsg := tls.ClientHelloMsg{}
+					// 尝试将负载数据反序列化为 ClientHelloMsg 实例
+					clientHelloMsg.UnmarshalByByte(buf)
+					// 如果反序列化后得到了 ServerName
+					protocol := blacklistRule.PROTOCOL_TLS
+					protocolPointer.Store(&protocol)
+					if clientHelloMsg.ServerName != "" {
+						if domain == "" {
 							domainPointer.Store(&clientHelloMsg.ServerName)
-							return
 						}
+						return
 					}
+				}
 
-					// 解析 HTTP 请求
-					hr, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf)))
-					// 如果解析成功
-					if err == nil {
-						// 从 HTTP 请求头中获取 Host 字段作为 ServerName
-						ServerName := hr.Header.Get("Host")
-						if ServerName != "" {
+				// 解析 HTTP 请求
+				hr, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf)))
+				// 如果解析成功
+				if err == nil {
+					protocol := blacklistRule.PROTOCOL_HTTP
+					protocolPointer.Store(&protocol)
+					// 从 HTTP 请求头中获取 Host 字段作为 ServerName
+					ServerName := hr.Header.Get("Host")
+					if ServerName != "" {
+						if domain == "" {
 							domainPointer.Store(&ServerName)
-							return
 						}
-
+						return
 					}
 
-					ServerName := regexpDomain(string(buf))
+				}
 
-					if ServerName != "" {
-						domainPointer.Store(&ServerName)
-					}
+				if domain != "" {
+					return
 				}
-			}
-		}()
 
-	}
+				ServerName := regexpDomain(string(buf))
+
+				if ServerName != "" {
+					domainPointer.Store(&ServerName)
+				}
+			}
+		}
+	}()
 
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		_, err := io.CopyBuffer(netTarget, NewLimitedReader(connCtx.ctx, netConn, action), make([]byte, 2*1024))
+		_, err := io.CopyBuffer(netTarget, NewLimitedReader(relayCtx, newCountReader(netConn, &traffic.pending, &stats.upload), upActions...), make([]byte, 2*1024))
 		errCh <- err
 	}()
 
 	go func() {
 		defer wg.Done()
-		_, err := io.CopyBuffer(netConn, NewLimitedReader(connCtx.ctx, netTarget, action), make([]byte, 2*1024))
+		_, err := io.CopyBuffer(netConn, NewLimitedReader(relayCtx, newCountReader(netTarget, &traffic.pending, &stats.download), downActions...), make([]byte, 2*1024))
 		errCh <- err
 	}()
 
-	loopTime := 30 * time.Second
-	ticker := time.NewTicker(loopTime)
-	defer ticker.Stop()
-
 	for {
-		ticker.Reset(loopTime)
 		select {
-
-		case <-ticker.C:
-			domain := domainPointer.Load()
-			if domain != nil && *domain != "" {
-				if black, in := m.IsInBlacklist(*domain); in {
-					m.SendBlackListAccessLogMessageData(user, pwd, black, 1, user, proxyServerIpStr)
-					log.Error("[socks_proxy_handler] 黑名单定时检测",
-						zap.Any("domain", domain),
-						zap.Error(err),
-						zap.Any("username", user),
-						zap.Any("clientAddr", proxyServerIpStr),
-						zap.Any("target_host", destAddr.Address()),
-					)
-
-					return
-				}
+		case <-blockCtx.Done():
+			if ctx.Err() == nil {
+				stats.closeReason = CLOSE_REASON_BLACKLIST
+				return
 			}
+			stats.closeReason = CLOSE_REASON_SHUTDOWN
+			return
 		case err, _ := <-errCh:
+			stats.closeReason = CLOSE_REASON_EOF
 			if err != nil {
+				stats.closeReason = CLOSE_REASON_ERROR
 				log.Error("[socks_proxy_handler] conn close!",
 					zap.Error(err),
 					zap.Any("username", user),
@@ -269,8 +371,13 @@ func (m *manager) socksTcpConn(ctx context.Context, conn net.Conn) {
 
 			return
 		case <-ctx.Done():
+			stats.closeReason = CLOSE_REASON_SHUTDOWN
 			return
 		case <-connCtx.ctx.Done():
+			stats.closeReason = CLOSE_REASON_KICKED
+			return
+		case <-sessionCtx.Done():
+			stats.closeReason = CLOSE_REASON_EVICTED
 			return
 
 		}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap" // 高性能日志库
+
+	"proxy_server/common"
+	"proxy_server/log"
+	"proxy_server/protobuf"
+	"proxy_server/server/lua"
+)
+
+const (
+	QUOTA_PERIOD_DAY   = "day"
+	QUOTA_PERIOD_MONTH = "month"
+)
+
+// userTraffic 账号待上报的流量 由该账号的全部连接共享
+type userTraffic struct {
+	pending  atomic.Int64                      // 尚未写入redis的字节数
+	retry    atomic.Pointer[trafficBatch]      // 上报结果未确认的批次 以相同批次号重试
+	authInfo atomic.Pointer[protobuf.AuthInfo] // 最近一次鉴权得到的账号信息 用于读取配额
+	c        int64                             // 使用中的连接数
+}
+
+// trafficBatch 一次上报的流量 重试时沿用批次号及统计键 由redis按批次号去重
+type trafficBatch struct {
+	id       string
+	bytes    int64
+	dayKey   string
+	monthKey string
+}
+
+// unflushed 返回尚未确认写入redis的字节数
+func (t *userTraffic) unflushed() int64 {
+	n := t.pending.Load()
+	if batch := t.retry.Load(); batch != nil {
+		n += batch.bytes
+	}
+	return n
+}
+
+// countReader 统计读取的字节数
+type countReader struct {
+	r        io.Reader
+	counters []*atomic.Int64
+}
+
+func newCountReader(r io.Reader, counters ...*atomic.Int64) io.Reader {
+	return &countReader{r: r, counters: counters}
+}
+
+func (c *countReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	if n > 0 {
+		for _, counter := range c.counters {
+			counter.Add(int64(n))
+		}
+	}
+	return
+}
+
+// userTrafficKeys 返回账号当天及当月的流量统计键
+func userTrafficKeys(username string, now time.Time) (dayKey, monthKey string) {
+	dayKey = fmt.Sprintf("%s_%s_%s", REDIS_TRAFFIC_DAY, username, now.Format("20060102"))
+	monthKey = fmt.Sprintf("%s_%s_%s", REDIS_TRAFFIC_MONTH, username, now.Format("200601"))
+	return
+}
+
+func (m *manager) addUserTraffic(username string, authInfo *protobuf.AuthInfo) *userTraffic {
+	return m.userTrafficMap.Upsert(username, nil, func(exist bool, valueInMap *userTraffic, newValue *userTraffic) *userTraffic {
+		if !exist {
+			valueInMap = &userTraffic{}
+		}
+		valueInMap.c++
+		valueInMap.authInfo.Store(authInfo)
+		return valueInMap
+	})
+}
+
+func (m *manager) deleteUserTraffic(username string, traffic *userTraffic) {
+	m.userTrafficMap.RemoveCb(username, func(key string, valueInMap *userTraffic, exists bool) bool {
+		if !exists || valueInMap != traffic {
+			return false
+		}
+		valueInMap.c--
+		// 还有未上报的流量时保留 由定时任务上报后删除
+		return valueInMap.c <= 0 && valueInMap.unflushed() == 0
+	})
+}
+
+// checkUserQuota 新连接检测账号流量配额 redis故障时不阻断连接
+func (m *manager) checkUserQuota(ctx context.Context, authInfo *protobuf.AuthInfo) error {
+	if authInfo.DailyQuotaBytes <= 0 && authInfo.MonthlyQuotaBytes <= 0 {
+		return nil
+	}
+
+	dayKey, monthKey := userTrafficKeys(authInfo.Username, time.Now())
+	vals, err := common.GetRedisDB().MGet(ctx, dayKey, monthKey).Result()
+	if err != nil {
+		log.Error("[traffic_quota] 读取账号流量失败", zap.Error(err), zap.Any("user", authInfo.Username))
+		return nil
+	}
+
+	var pending int64
+	if traffic, ok := m.userTrafficMap.Get(authInfo.Username); ok {
+		pending = traffic.unflushed()
+	}
+
+	dayUsed := parseTrafficValue(vals[0]) + pending
+	if authInfo.DailyQuotaBytes > 0 && dayUsed >= authInfo.DailyQuotaBytes {
+		return fmt.Errorf("%s用户当日流量配额已用完 used:%d quota:%d", authInfo.Username, dayUsed, authInfo.DailyQuotaBytes)
+	}
+
+	monthUsed := parseTrafficValue(vals[1]) + pending
+	if authInfo.MonthlyQuotaBytes > 0 && monthUsed >= authInfo.MonthlyQuotaBytes {
+		return fmt.Errorf("%s用户当月流量配额已用完 used:%d quota:%d", authInfo.Username, monthUsed, authInfo.MonthlyQuotaBytes)
+	}
+
+	return nil
+}
+
+func parseTrafficValue(v interface{}) int64 {
+	s, ok := v.(string)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+// runUserTrafficFlush 定时将账号流量批量累加到redis 并检测配额
+func (m *manager) runUserTrafficFlush(ctx context.Context) {
+	loopTime := 5 * time.Second
+	ticker := time.NewTicker(loopTime)
+	defer ticker.Stop()
+
+	for {
+		ticker.Reset(loopTime)
+		select {
+		case <-ctx.Done():
+			// 退出前上报剩余流量
+			m.flushUserTraffic(context.Background())
+			return
+		case <-ticker.C:
+			m.flushUserTraffic(ctx)
+		}
+	}
+}
+
+func (m *manager) flushUserTraffic(ctx context.Context) {
+	type flushItem struct {
+		username string
+		traffic  *userTraffic
+		batch    *trafficBatch
+		op       *redis.Cmd
+	}
+
+	now := time.Now()
+	items := []*flushItem{}
+	pipe := common.GetRedisDB().Pipeline()
+	for v := range m.userTrafficMap.Iter() {
+		// 上次结果未确认的批次优先重试 新增流量留到下次上报
+		batch := v.Val.retry.Load()
+		if batch == nil {
+			n := v.Val.pending.Swap(0)
+			if n == 0 {
+				m.userTrafficMap.RemoveCb(v.Key, func(key string, valueInMap *userTraffic, exists bool) bool {
+					return exists && valueInMap == v.Val && valueInMap.c <= 0 && valueInMap.unflushed() == 0
+				})
+				continue
+			}
+
+			dayKey, monthKey := userTrafficKeys(v.Key, now)
+			batch = &trafficBatch{
+				id:       fmt.Sprintf("%s_%d", clusterNodeId(), now.UnixNano()),
+				bytes:    n,
+				dayKey:   dayKey,
+				monthKey: monthKey,
+			}
+			v.Val.retry.Store(batch)
+		}
+
+		batchKey := fmt.Sprintf("%s_%s_%s", REDIS_TRAFFIC_BATCH, v.Key, batch.id)
+		item := &flushItem{username: v.Key, traffic: v.Val, batch: batch}
+		item.op = pipe.EvalSha(ctx, lua.UserTrafficLuaScriptShaCode, []string{batch.dayKey, batch.monthKey, batchKey},
+			batch.bytes, int64((48 * time.Hour).Seconds()), int64((32 * 24 * time.Hour).Seconds()), int64(time.Hour.Seconds()))
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		return
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Error("[traffic_quota] 上报账号流量失败", zap.Error(err), zap.Any("count", len(items)))
+	}
+
+	for _, item := range items {
+		vals, err := item.op.Int64Slice()
+		if err != nil || len(vals) != 2 {
+			// 结果未确认 保留批次 下次以相同批次号重试
+			continue
+		}
+		item.traffic.retry.CompareAndSwap(item.batch, nil)
+
+		authInfo := item.traffic.authInfo.Load()
+		if authInfo == nil {
+			continue
+		}
+
+		// 跨天或跨月重试的批次不参与当前周期的配额判断
+		dayKey, monthKey := userTrafficKeys(item.username, now)
+		dayUsed, monthUsed := vals[0], vals[1]
+		if authInfo.DailyQuotaBytes > 0 && item.batch.dayKey == dayKey && dayUsed >= authInfo.DailyQuotaBytes {
+			m.onUserQuotaExhausted(item.username, QUOTA_PERIOD_DAY+now.Format("20060102"), QUOTA_PERIOD_DAY, dayUsed, authInfo.DailyQuotaBytes)
+			continue
+		}
+
+		if authInfo.MonthlyQuotaBytes > 0 && item.batch.monthKey == monthKey && monthUsed >= authInfo.MonthlyQuotaBytes {
+			m.onUserQuotaExhausted(item.username, QUOTA_PERIOD_MONTH+now.Format("200601"), QUOTA_PERIOD_MONTH, monthUsed, authInfo.MonthlyQuotaBytes)
+		}
+	}
+}
+
+// onUserQuotaExhausted 配额耗尽 关闭账号全部连接 同一周期只上报一次事件
+func (m *manager) onUserQuotaExhausted(username, periodId, period string, used, quota int64) {
+	for v := range m.userCtxMap.Iter() {
+		keys := strings.SplitN(v.Key, ":", 2)
+		if len(keys) != 2 || keys[0] != username {
+			continue
+		}
+
+		connCtx := v.Val
+		removed := m.userCtxMap.RemoveCb(v.Key, func(key string, valueInMap *connContext, exists bool) bool {
+			return exists && valueInMap == connCtx
+		})
+		if removed {
+			connCtx.cancel()
+		}
+	}
+
+	if last, ok := m.quotaEventMap.Get(username); ok && last == periodId {
+		return
+	}
+	m.quotaEventMap.Set(username, periodId)
+
+	log.Info("[traffic_quota] 账号流量配额已用完", zap.Any("user", username), zap.Any("period", period), zap.Any("used", used), zap.Any("quota", quota))
+	if err := m.SendQuotaExhaustedMessageData(username, period, used, quota); err != nil {
+		log.Error("[traffic_quota] 上报配额耗尽事件失败", zap.Error(err))
+	}
+}
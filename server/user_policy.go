@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"proxy_server/protobuf"
+	"proxy_server/utils/blacklistRule"
+)
+
+// userPolicy 账号编译后的允许及禁止列表 列表内容未变化时使用缓存
+// update_unix只精确到秒 同一秒内多次更新时不能用来判断列表是否变化
+type userPolicy struct {
+	allowRules []*protobuf.BlacklistRule
+	denyRules  []*protobuf.BlacklistRule
+	allow      *blacklistRule.Matcher // 为nil表示不限制
+	deny       *blacklistRule.Matcher
+	err        error
+}
+
+func compileUserPolicy(authInfo *protobuf.AuthInfo) *userPolicy {
+	policy := &userPolicy{allowRules: authInfo.AllowRules, denyRules: authInfo.DenyRules}
+	if len(authInfo.AllowRules) > 0 {
+		policy.allow, policy.err = blacklistRule.Compile(convertBlacklistRules(authInfo.AllowRules))
+		if policy.err != nil {
+			policy.err = fmt.Errorf("%s用户允许列表不合法 %w", authInfo.Username, policy.err)
+			return policy
+		}
+	}
+	if len(authInfo.DenyRules) > 0 {
+		policy.deny, policy.err = blacklistRule.Compile(convertBlacklistRules(authInfo.DenyRules))
+		if policy.err != nil {
+			policy.err = fmt.Errorf("%s用户禁止列表不合法 %w", authInfo.Username, policy.err)
+		}
+	}
+	return policy
+}
+
+func equalRules(a, b []*protobuf.BlacklistRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// getUserPolicy 返回账号的允许及禁止列表 列表未变化时使用缓存
+func (m *manager) getUserPolicy(authInfo *protobuf.AuthInfo) *userPolicy {
+	if policy, ok := m.userPolicyMap.Get(authInfo.Username); ok &&
+		equalRules(policy.allowRules, authInfo.AllowRules) && equalRules(policy.denyRules, authInfo.DenyRules) {
+		return policy
+	}
+	policy := compileUserPolicy(authInfo)
+	m.userPolicyMap.Set(authInfo.Username, policy)
+	return policy
+}
+
+// checkUserPolicy 在全局黑名单之后检测账号的允许及禁止列表 不允许访问时返回原因
+// 列表不合法时拒绝访问
+func (m *manager) checkUserPolicy(authInfo *protobuf.AuthInfo, target blacklistRule.Target) (string, bool) {
+	if authInfo == nil || (len(authInfo.AllowRules) == 0 && len(authInfo.DenyRules) == 0) {
+		return "", true
+	}
+
+	policy := m.getUserPolicy(authInfo)
+	if policy.err != nil {
+		return policy.err.Error(), false
+	}
+
+	if rule, ok := policy.deny.Match(target); ok {
+		return "deny:" + rule.String(), false
+	}
+
+	if policy.allow != nil && !matchAllowRule(policy.allow, target) {
+		return "allow:未命中允许列表", false
+	}
+	return "", true
+}
+
+// matchAllowRule 协议未识别时按任一协议命中即允许 识别出协议后由checkLiveSession再次确认
+func matchAllowRule(allow *blacklistRule.Matcher, target blacklistRule.Target) bool {
+	if _, ok := allow.Match(target); ok {
+		return true
+	}
+	if target.Protocol != blacklistRule.PROTOCOL_ANY {
+		return false
+	}
+	for _, protocol := range []string{blacklistRule.PROTOCOL_HTTP, blacklistRule.PROTOCOL_TLS} {
+		target.Protocol = protocol
+		if _, ok := allow.Match(target); ok {
+			return true
+		}
+	}
+	return false
+}
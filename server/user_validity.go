@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap" // 高性能日志库
+
+	"proxy_server/log"
+	"proxy_server/protobuf"
+)
+
+// checkAuthInfoValidity 校验账号是否在有效期内以及是否处于每周可用时间段
+func checkAuthInfoValidity(authInfo *protobuf.AuthInfo, now time.Time) error {
+	nowUnix := now.Unix()
+	if authInfo.ValidFrom != 0 && nowUnix < authInfo.ValidFrom {
+		return fmt.Errorf("%s用户未到生效时间 valid_from:%d", authInfo.Username, authInfo.ValidFrom)
+	}
+
+	if authInfo.ExpireUnix != 0 && nowUnix >= authInfo.ExpireUnix {
+		return fmt.Errorf("%s用户已过期 expire_unix:%d", authInfo.Username, authInfo.ExpireUnix)
+	}
+
+	if len(authInfo.AccessSchedule) > 0 && !inAccessSchedule(authInfo.AccessSchedule, now) {
+		return fmt.Errorf("%s用户不在可用时间段内", authInfo.Username)
+	}
+
+	return nil
+}
+
+// inAccessSchedule 判断当前时间是否落在任意一个每周可用时间段内
+func inAccessSchedule(schedule []*protobuf.AccessWindow, now time.Time) bool {
+	weekday := int32(now.Weekday())
+	minute := int32(now.Hour()*60 + now.Minute())
+	for _, w := range schedule {
+		if w.Weekday == weekday && minute >= w.StartMinute && minute < w.EndMinute {
+			return true
+		}
+	}
+	return false
+}
+
+// runUserValidityCheck 定时关闭已过期或不在可用时间段内的账号连接
+func (m *manager) runUserValidityCheck(ctx context.Context) {
+	loopTime := 5 * time.Second
+	ticker := time.NewTicker(loopTime)
+	defer ticker.Stop()
+
+	for {
+		ticker.Reset(loopTime)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.closeInvalidUserConnections(time.Now())
+		}
+	}
+}
+
+func (m *manager) closeInvalidUserConnections(now time.Time) {
+	for v := range m.userCtxMap.Iter() {
+		authInfo := v.Val.authInfo.Load()
+		if authInfo == nil {
+			continue
+		}
+
+		err := checkAuthInfoValidity(authInfo, now)
+		if err == nil {
+			continue
+		}
+
+		connCtx := v.Val
+		removed := m.userCtxMap.RemoveCb(v.Key, func(key string, valueInMap *connContext, exists bool) bool {
+			return exists && valueInMap == connCtx
+		})
+		if removed {
+			connCtx.cancel()
+			log.Info("[user_validity] 账号已失效 关闭连接", zap.Any("key", v.Key), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package blacklistRule
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"proxy_server/utils/domainTrie"
+)
+
+// 规则类型
+const (
+	RULE_DOMAIN   = "domain"   // 精确域名
+	RULE_WILDCARD = "wildcard" // 通配 *.example.com 只匹配子域名
+	RULE_SUFFIX   = "suffix"   // 域名及其子域名 旧版域名列表按此类型处理
+	RULE_REGEX    = "regex"    // 正则 匹配完整域名
+	RULE_CIDR     = "cidr"     // ip或网段 只匹配以ip访问的目标
+	RULE_PORT     = "port"     // 只按端口匹配
+)
+
+// 协议
+const (
+	PROTOCOL_ANY  = ""
+	PROTOCOL_HTTP = "http"
+	PROTOCOL_TLS  = "tls"
+)
+
+// Rule 单条黑名单规则
+type Rule struct {
+	Id       string // 规则标识 命中时上报 为空时使用 类型:值[:端口][@协议]
+	Type     string
+	Value    string
+	Ports    []int  // 限定端口 为空表示不限制 类型为port时即要屏蔽的端口
+	Protocol string // 限定协议 为空表示不限制
+}
+
+// Target 待检测的目标
+type Target struct {
+	Host     string // 域名或ip
+	Port     int
+	Protocol string // 未识别时为空 此时限定协议的规则不匹配
+}
+
+// String 返回规则标识 未设置Id时由类型、值、端口及协议组成 避免不同规则上报相同的标识
+func (r *Rule) String() string {
+	if r.Id != "" {
+		return r.Id
+	}
+	ports := make([]string, 0, len(r.Ports))
+	for _, port := range r.Ports {
+		ports = append(ports, strconv.Itoa(port))
+	}
+
+	id := r.Type + ":" + r.Value
+	if r.Type == RULE_PORT {
+		id = r.Type + ":" + strings.Join(ports, ",")
+	} else if len(ports) > 0 {
+		id += ":" + strings.Join(ports, ",")
+	}
+	if r.Protocol != PROTOCOL_ANY {
+		id += "@" + r.Protocol
+	}
+	return id
+}
+
+// allow 端口及协议限定是否满足
+func (r *Rule) allow(t *Target) bool {
+	if r.Protocol != PROTOCOL_ANY && r.Protocol != t.Protocol {
+		return false
+	}
+	if len(r.Ports) == 0 {
+		return true
+	}
+	for _, port := range r.Ports {
+		if port == t.Port {
+			return true
+		}
+	}
+	return false
+}
+
+type regexRule struct {
+	re   *regexp.Regexp
+	rule *Rule
+}
+
+type cidrRule struct {
+	ipNet *net.IPNet
+	rule  *Rule
+}
+
+// Matcher 编译后的黑名单 构建后只读 可并发查询
+type Matcher struct {
+	exact    map[string][]*Rule
+	suffix   *domainTrie.Trie
+	wildcard *domainTrie.Trie
+	domains  map[string][]*Rule // 后缀及通配规则 域名 -> 规则
+	regexes  []regexRule
+	cidrs    []cidrRule
+	ports    []*Rule
+	size     int
+}
+
+func normalizeDomain(domain string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// Compile 编译规则 任一规则不合法时返回错误
+func Compile(rules []Rule) (*Matcher, error) {
+	m := &Matcher{
+		exact:   map[string][]*Rule{},
+		domains: map[string][]*Rule{},
+	}
+	suffixes := []string{}
+	wildcards := []string{}
+
+	for i := range rules {
+		rule := rules[i]
+		switch rule.Protocol {
+		case PROTOCOL_ANY, PROTOCOL_HTTP, PROTOCOL_TLS:
+		default:
+			return nil, fmt.Errorf("规则%s协议%s不支持", rule.String(), rule.Protocol)
+		}
+
+		switch rule.Type {
+		case RULE_DOMAIN:
+			domain := normalizeDomain(rule.Value)
+			if domain == "" {
+				return nil, fmt.Errorf("规则%s域名为空", rule.String())
+			}
+			m.exact[domain] = append(m.exact[domain], &rule)
+		case RULE_SUFFIX:
+			domain := normalizeDomain(strings.TrimPrefix(strings.TrimSpace(rule.Value), "*."))
+			if domain == "" {
+				return nil, fmt.Errorf("规则%s域名为空", rule.String())
+			}
+			suffixes = append(suffixes, domain)
+			m.domains[RULE_SUFFIX+domain] = append(m.domains[RULE_SUFFIX+domain], &rule)
+		case RULE_WILDCARD:
+			value := strings.TrimSpace(rule.Value)
+			if !strings.HasPrefix(value, "*.") {
+				return nil, fmt.Errorf("规则%s通配域名需以*.开头", rule.String())
+			}
+			domain := normalizeDomain(value[2:])
+			if domain == "" {
+				return nil, fmt.Errorf("规则%s域名为空", rule.String())
+			}
+			wildcards = append(wildcards, domain)
+			m.domains[RULE_WILDCARD+domain] = append(m.domains[RULE_WILDCARD+domain], &rule)
+		case RULE_REGEX:
+			re, err := regexp.Compile(rule.Value)
+			if err != nil {
+				return nil, fmt.Errorf("规则%s正则不合法 error:%+v", rule.String(), err)
+			}
+			m.regexes = append(m.regexes, regexRule{re: re, rule: &rule})
+		case RULE_CIDR:
+			ipNet, err := parseCIDR(rule.Value)
+			if err != nil {
+				return nil, fmt.Errorf("规则%s网段不合法 error:%+v", rule.String(), err)
+			}
+			m.cidrs = append(m.cidrs, cidrRule{ipNet: ipNet, rule: &rule})
+		case RULE_PORT:
+			if len(rule.Ports) == 0 {
+				return nil, fmt.Errorf("规则%s端口为空", rule.String())
+			}
+			m.ports = append(m.ports, &rule)
+		default:
+			return nil, fmt.Errorf("规则%s类型%s不支持", rule.String(), rule.Type)
+		}
+		m.size++
+	}
+
+	m.suffix = domainTrie.New(suffixes)
+	m.wildcard = domainTrie.New(wildcards)
+	return m, nil
+}
+
+// parseCIDR 解析网段 单个ip按全掩码处理
+func parseCIDR(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("ip %s 不合法", value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	return ipNet, err
+}
+
+// Len 返回规则数
+func (m *Matcher) Len() int {
+	if m == nil {
+		return 0
+	}
+	return m.size
+}
+
+// Match 返回目标命中的第一条规则
+func (m *Matcher) Match(t Target) (*Rule, bool) {
+	if m == nil || m.size == 0 {
+		return nil, false
+	}
+
+	host := strings.Trim(t.Host, "[]")
+	if ip := net.ParseIP(host); ip != nil {
+		for _, c := range m.cidrs {
+			if c.ipNet.Contains(ip) && c.rule.allow(&t) {
+				return c.rule, true
+			}
+		}
+	} else if host != "" {
+		if rule, ok := m.matchDomain(normalizeDomain(host), &t); ok {
+			return rule, true
+		}
+	}
+
+	for _, rule := range m.ports {
+		if rule.allow(&t) {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+func (m *Matcher) matchDomain(domain string, t *Target) (*Rule, bool) {
+	for _, rule := range m.exact[domain] {
+		if rule.allow(t) {
+			return rule, true
+		}
+	}
+
+	var matched *Rule
+	find := func(prefix string) func(entry string) bool {
+		return func(entry string) bool {
+			// 通配规则不匹配域名本身
+			if prefix == RULE_WILDCARD && entry == domain {
+				return false
+			}
+			for _, rule := range m.domains[prefix+entry] {
+				if rule.allow(t) {
+					matched = rule
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if m.suffix.MatchFunc(domain, find(RULE_SUFFIX)) || m.wildcard.MatchFunc(domain, find(RULE_WILDCARD)) {
+		return matched, true
+	}
+
+	for _, r := range m.regexes {
+		if r.re.MatchString(domain) && r.rule.allow(t) {
+			return r.rule, true
+		}
+	}
+	return nil, false
+}
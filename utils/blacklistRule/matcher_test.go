@@ -0,0 +1,101 @@
+package blacklistRule
+
+import (
+	"fmt"
+	"testing"
+)
+
+// go test -run TestMatcher -v
+func TestMatcher(t *testing.T) {
+	m, err := Compile([]Rule{
+		{Type: RULE_DOMAIN, Value: "exact.com"},
+		{Type: RULE_WILDCARD, Value: "*.wild.com"},
+		{Type: RULE_SUFFIX, Value: "suffix.com"},
+		{Id: "re", Type: RULE_REGEX, Value: `^ads\d+\.`},
+		{Type: RULE_CIDR, Value: "10.0.0.0/8"},
+		{Type: RULE_CIDR, Value: "1.2.3.4"},
+		{Type: RULE_PORT, Ports: []int{25}},
+		{Type: RULE_SUFFIX, Value: "tls-only.com", Protocol: PROTOCOL_TLS},
+		{Type: RULE_DOMAIN, Value: "http-only.com", Protocol: PROTOCOL_HTTP, Ports: []int{80}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		target Target
+		rule   string
+	}{
+		{Target{Host: "exact.com", Port: 443}, "domain:exact.com"},
+		{Target{Host: "www.exact.com", Port: 443}, ""},
+		{Target{Host: "a.wild.com", Port: 443}, "wildcard:*.wild.com"},
+		{Target{Host: "wild.com", Port: 443}, ""},
+		{Target{Host: "suffix.com", Port: 443}, "suffix:suffix.com"},
+		{Target{Host: "x.Suffix.com", Port: 443}, "suffix:suffix.com"},
+		{Target{Host: "mysuffix.com", Port: 443}, ""},
+		{Target{Host: "ads12.example.com", Port: 443}, "re"},
+		{Target{Host: "10.1.2.3", Port: 443}, "cidr:10.0.0.0/8"},
+		{Target{Host: "1.2.3.4", Port: 443}, "cidr:1.2.3.4"},
+		{Target{Host: "1.2.3.5", Port: 443}, ""},
+		{Target{Host: "mail.example.com", Port: 25}, "port:25"},
+		{Target{Host: "a.tls-only.com", Port: 443}, ""},
+		{Target{Host: "a.tls-only.com", Port: 443, Protocol: PROTOCOL_TLS}, "suffix:tls-only.com@tls"},
+		{Target{Host: "http-only.com", Port: 80, Protocol: PROTOCOL_HTTP}, "domain:http-only.com:80@http"},
+		{Target{Host: "http-only.com", Port: 8080, Protocol: PROTOCOL_HTTP}, ""},
+		{Target{Host: "http-only.com", Port: 80, Protocol: PROTOCOL_TLS}, ""},
+	}
+	for _, c := range cases {
+		rule, ok := m.Match(c.target)
+		got := ""
+		if ok {
+			got = rule.String()
+		}
+		if got != c.rule {
+			t.Errorf("%+v 命中%q 期望%q", c.target, got, c.rule)
+		}
+	}
+}
+
+// go test -run TestCompileError -v
+func TestCompileError(t *testing.T) {
+	invalid := []Rule{
+		{Type: "unknown", Value: "a.com"},
+		{Type: RULE_DOMAIN, Value: ""},
+		{Type: RULE_WILDCARD, Value: "a.com"},
+		{Type: RULE_REGEX, Value: "("},
+		{Type: RULE_CIDR, Value: "300.1.1.1"},
+		{Type: RULE_PORT},
+		{Type: RULE_DOMAIN, Value: "a.com", Protocol: "ftp"},
+	}
+	for _, rule := range invalid {
+		if _, err := Compile([]Rule{rule}); err == nil {
+			t.Errorf("%+v 应编译失败", rule)
+		}
+	}
+}
+
+// go test -bench=BenchmarkMatcher -run=none -benchmem
+func BenchmarkMatcher(b *testing.B) {
+	rules := make([]Rule, 0, 100000)
+	for i := 0; i < 100000; i++ {
+		ruleType := RULE_SUFFIX
+		value := fmt.Sprintf("domain%d.com", i)
+		switch i % 4 {
+		case 1:
+			ruleType = RULE_DOMAIN
+		case 2:
+			ruleType, value = RULE_WILDCARD, "*."+value
+		}
+		rules = append(rules, Rule{Type: ruleType, Value: value})
+	}
+	rules = append(rules, Rule{Type: RULE_CIDR, Value: "10.0.0.0/8"}, Rule{Type: RULE_PORT, Ports: []int{25}})
+	m, err := Compile(rules)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Match(Target{Host: "www.notlisted.com", Port: 443, Protocol: PROTOCOL_TLS})
+	}
+}
@@ -0,0 +1,115 @@
+package domainTrie
+
+import "strings"
+
+// Trie 按域名标签倒序构建的后缀树 构建后只读 可并发查询
+// 条目example.com匹配example.com及其任意子域名 不匹配ample.com这类非标签边界的后缀
+type Trie struct {
+	root *node
+	size int
+}
+
+type node struct {
+	children map[string]*node
+	entry    string // 该节点对应的原始条目 为空表示不是条目结尾
+}
+
+// normalize 统一小写 去掉首尾的点及通配前缀*.
+func normalize(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "*.")
+	return strings.Trim(domain, ".")
+}
+
+// New 由条目构建后缀树 空条目会被忽略
+func New(entries []string) *Trie {
+	t := &Trie{root: &node{}}
+	for _, entry := range entries {
+		t.insert(entry)
+	}
+	return t
+}
+
+func (t *Trie) insert(entry string) {
+	domain := normalize(entry)
+	if domain == "" {
+		return
+	}
+
+	n := t.root
+	for end := len(domain); end > 0; {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		label := domain[start:end]
+		child, ok := n.children[label]
+		if !ok {
+			if n.children == nil {
+				n.children = map[string]*node{}
+			}
+			child = &node{}
+			n.children[label] = child
+		}
+		n = child
+		end = start - 1
+	}
+
+	if n.entry == "" {
+		t.size++
+	}
+	n.entry = entry
+}
+
+// Len 返回条目数
+func (t *Trie) Len() int {
+	if t == nil {
+		return 0
+	}
+	return t.size
+}
+
+// Match 判断域名是否等于某个条目或是其子域名 返回匹配到的原始条目
+// 多个条目同时匹配时返回层级最短的条目
+func (t *Trie) Match(domain string) (string, bool) {
+	var matched string
+	ok := t.MatchFunc(domain, func(entry string) bool {
+		matched = entry
+		return true
+	})
+	return matched, ok
+}
+
+// MatchFunc 按层级从短到长依次回调匹配到的条目 回调返回true时停止并返回true
+func (t *Trie) MatchFunc(domain string, fn func(entry string) bool) bool {
+	if t == nil || t.size == 0 {
+		return false
+	}
+
+	domain = strings.Trim(domain, ".")
+	if hasUpper(domain) {
+		domain = strings.ToLower(domain)
+	}
+
+	n := t.root
+	for end := len(domain); end > 0; {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		child, ok := n.children[domain[start:end]]
+		if !ok {
+			return false
+		}
+		if child.entry != "" && fn(child.entry) {
+			return true
+		}
+		n = child
+		end = start - 1
+	}
+	return false
+}
+
+// hasUpper 查询的域名通常已是小写 避免每次都分配新字符串
+func hasUpper(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'Z' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package domainTrie
+
+import (
+	"fmt"
+	"testing"
+)
+
+// go test -run TestTrieMatch -v
+func TestTrieMatch(t *testing.T) {
+	trie := New([]string{"example.com", "*.google.com", "bad.org.", "Upper.NET", ""})
+	if trie.Len() != 4 {
+		t.Fatalf("条目数%d 期望4", trie.Len())
+	}
+
+	cases := []struct {
+		domain string
+		entry  string
+		ok     bool
+	}{
+		{"example.com", "example.com", true},
+		{"www.example.com", "example.com", true},
+		{"a.b.example.com", "example.com", true},
+		{"ample.com", "", false},
+		{"myexample.com", "", false},
+		{"example.com.cn", "", false},
+		{"com", "", false},
+		{"google.com", "*.google.com", true},
+		{"mail.google.com", "*.google.com", true},
+		{"bad.org", "bad.org.", true},
+		{"x.bad.org.", "bad.org.", true},
+		{"WWW.Example.COM", "example.com", true},
+		{"upper.net", "Upper.NET", true},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		entry, ok := trie.Match(c.domain)
+		if ok != c.ok || entry != c.entry {
+			t.Errorf("%q 匹配结果(%q, %v) 期望(%q, %v)", c.domain, entry, ok, c.entry, c.ok)
+		}
+	}
+
+	var empty *Trie
+	if _, ok := empty.Match("example.com"); ok {
+		t.Fatal("空后缀树不应匹配")
+	}
+}
+
+func entries(n int) []string {
+	list := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		list = append(list, fmt.Sprintf("domain%d.site%d.com", i, i%100))
+	}
+	return list
+}
+
+// go test -bench=BenchmarkTrieBuild -run=none -benchmem
+func BenchmarkTrieBuild(b *testing.B) {
+	list := entries(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		New(list)
+	}
+}
+
+// go test -bench=BenchmarkTrieMatch -run=none -benchmem
+func BenchmarkTrieMatch(b *testing.B) {
+	trie := New(entries(100000))
+	b.Run("hit", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			trie.Match("www.domain99999.site99.com")
+		}
+	})
+	b.Run("miss", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			trie.Match("www.notlisted.site99.com")
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				trie.Match(fmt.Sprintf("img.domain%d.site%d.com", i%200000, i%100))
+				i++
+			}
+		})
+	})
+}
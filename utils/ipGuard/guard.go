@@ -0,0 +1,92 @@
+package ipGuard
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DefaultDenyCIDRs 默认禁止访问的网段 本机、私有、链路本地、运营商NAT、组播及保留地址
+var DefaultDenyCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// Guard 目标地址检测 构建后只读 可并发查询
+type Guard struct {
+	nets []*net.IPNet
+}
+
+// New 由网段构建 单个ip按全掩码处理 任一网段不合法时返回错误
+func New(cidrs []string) (*Guard, error) {
+	g := &Guard{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("ip %s 不合法", cidr)
+			}
+			g.AddIP(ip)
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("网段%s不合法 error:%+v", cidr, err)
+		}
+		g.nets = append(g.nets, ipNet)
+	}
+	return g, nil
+}
+
+// AddIP 追加单个禁止访问的ip 只能在构建阶段调用
+func (g *Guard) AddIP(ip net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		g.nets = append(g.nets, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+		return
+	}
+	g.nets = append(g.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+}
+
+// Len 返回网段数
+func (g *Guard) Len() int {
+	if g == nil {
+		return 0
+	}
+	return len(g.nets)
+}
+
+// Deny 判断ip是否禁止访问 返回命中的网段
+// ipv4映射的ipv6地址按ipv4检测 避免以::ffff:127.0.0.1绕过
+func (g *Guard) Deny(ip net.IP) (string, bool) {
+	if g == nil {
+		return "", false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, ipNet := range g.nets {
+		if ipNet.Contains(ip) {
+			return ipNet.String(), true
+		}
+	}
+	return "", false
+}
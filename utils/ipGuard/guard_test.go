@@ -0,0 +1,50 @@
+package ipGuard
+
+import (
+	"net"
+	"testing"
+)
+
+// go test -run TestGuardDeny -v
+func TestGuardDeny(t *testing.T) {
+	g, err := New(append(DefaultDenyCIDRs, "203.0.113.7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ip   string
+		cidr string
+	}{
+		{"127.0.0.1", "127.0.0.0/8"},
+		{"10.1.2.3", "10.0.0.0/8"},
+		{"172.31.255.255", "172.16.0.0/12"},
+		{"172.32.0.1", ""},
+		{"192.168.1.1", "192.168.0.0/16"},
+		{"169.254.169.254", "169.254.0.0/16"},
+		{"100.64.0.1", "100.64.0.0/10"},
+		{"0.0.0.0", "0.0.0.0/8"},
+		{"::1", "::1/128"},
+		{"::ffff:127.0.0.1", "127.0.0.0/8"},
+		{"fe80::1", "fe80::/10"},
+		{"fd00::1", "fc00::/7"},
+		{"203.0.113.7", "203.0.113.7/32"},
+		{"203.0.113.8", ""},
+		{"8.8.8.8", ""},
+		{"2001:4860:4860::8888", ""},
+	}
+	for _, c := range cases {
+		cidr, deny := g.Deny(net.ParseIP(c.ip))
+		if deny != (c.cidr != "") || cidr != c.cidr {
+			t.Errorf("%s 命中%q 期望%q", c.ip, cidr, c.cidr)
+		}
+	}
+
+	if _, err := New([]string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("不合法网段应构建失败")
+	}
+	var empty *Guard
+	if _, deny := empty.Deny(net.ParseIP("127.0.0.1")); deny {
+		t.Fatal("空检测不应拒绝")
+	}
+}
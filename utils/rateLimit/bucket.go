@@ -0,0 +1,156 @@
+package rateLimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket 令牌桶 令牌按rate匀速生成 最多累积burst个
+// 采用预约方式扣减令牌：令牌不足时余额可以为负，调用方按欠额精确计算需要等待的时间，
+// 先预约的调用方先拿到令牌，等待者按到达顺序公平唤醒，不需要轮询
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64   // 每秒生成的令牌数 小于等于0表示不限速
+	burst  float64   // 桶容量
+	tokens float64   // 当前令牌数 预约后可为负
+	last   time.Time // 上次结算令牌的时间
+}
+
+// NewBucket 创建令牌桶 初始为满桶
+// rate小于等于0表示不限速 burst小于等于0时使用rate
+func NewBucket(rate, burst int) *Bucket {
+	b := &Bucket{last: time.Now()}
+	b.setLimit(rate, burst)
+	b.tokens = b.burst
+	return b
+}
+
+func (b *Bucket) setLimit(rate, burst int) {
+	if burst <= 0 {
+		burst = rate
+	}
+	b.rate = float64(rate)
+	b.burst = float64(burst)
+}
+
+// SetLimit 更新速率及容量 已累积的令牌不超过新容量
+func (b *Bucket) SetLimit(rate, burst int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	b.setLimit(rate, burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Rate 返回每秒生成的令牌数
+func (b *Bucket) Rate() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(b.rate)
+}
+
+// Burst 返回桶容量
+func (b *Bucket) Burst() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(b.burst)
+}
+
+// Full 桶是否已满 即近期没有消耗
+func (b *Bucket) Full() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	return b.tokens >= b.burst
+}
+
+// advance 结算从上次到now生成的令牌
+func (b *Bucket) advance(now time.Time) {
+	elapsed := now.Sub(b.last)
+	if elapsed <= 0 {
+		return
+	}
+	b.last = now
+	if b.rate <= 0 {
+		b.tokens = b.burst
+		return
+	}
+	b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+}
+
+// reserve 预约n个令牌 返回需要等待的时长
+func (b *Bucket) reserve(now time.Time, n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate <= 0 {
+		return 0
+	}
+
+	b.advance(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund 归还未使用的预约令牌
+func (b *Bucket) refund(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = math.Min(b.burst, b.tokens+n)
+}
+
+// AllowN 令牌充足时立即扣减n个令牌并返回true 不足时不扣减返回false
+func (b *Bucket) AllowN(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate <= 0 {
+		return true
+	}
+
+	b.advance(time.Now())
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// WaitN 等待获取n个令牌 ctx取消时立即返回并归还预约的令牌
+// n超过桶容量时按容量分批预约
+func (b *Bucket) WaitN(ctx context.Context, n int) error {
+	for n > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunk := n
+		if burst := b.Burst(); burst > 0 && chunk > burst {
+			chunk = burst
+		}
+
+		wait := b.reserve(time.Now(), float64(chunk))
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				b.refund(float64(chunk))
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		n -= chunk
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package rateLimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// go test -run TestBucketRate -v
+func TestBucketRate(t *testing.T) {
+	rate := 64 * 1024
+	b := NewBucket(rate, 4*1024)
+
+	var total atomic.Int64
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b.WaitN(ctx, 2*1024) == nil {
+				total.Add(2 * 1024)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := float64(total.Load()) / time.Since(start).Seconds()
+	if got > float64(rate)*1.15 || got < float64(rate)*0.85 {
+		t.Fatalf("实际速率%.0f 期望速率%d", got, rate)
+	}
+}
+
+// go test -run TestBucketCancel -v
+func TestBucketCancel(t *testing.T) {
+	b := NewBucket(1024, 1024)
+	if err := b.WaitN(context.Background(), 1024); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := b.WaitN(ctx, 1024); err == nil {
+		t.Fatal("令牌不足时应等待至ctx取消")
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Fatalf("ctx取消后未及时返回 %v", d)
+	}
+
+	// 取消的预约应归还 不影响后续等待时间
+	start = time.Now()
+	if err := b.WaitN(context.Background(), 512); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 700*time.Millisecond {
+		t.Fatalf("取消的预约未归还 等待%v", d)
+	}
+}
+
+// go test -run TestBucketAllowN -v
+func TestBucketAllowN(t *testing.T) {
+	b := NewBucket(10, 3)
+	for i := 0; i < 3; i++ {
+		if !b.AllowN(1) {
+			t.Fatalf("第%d次应获取成功", i+1)
+		}
+	}
+	if b.AllowN(1) {
+		t.Fatal("令牌耗尽后应获取失败")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if !b.AllowN(1) {
+		t.Fatal("令牌恢复后应获取成功")
+	}
+}
+
+// go test -run TestBucketSetLimit -v
+func TestBucketSetLimit(t *testing.T) {
+	b := NewBucket(1024, 1024*1024)
+	b.SetLimit(2048, 1024)
+	if b.Rate() != 2048 || b.Burst() != 1024 {
+		t.Fatalf("参数未更新 rate:%d burst:%d", b.Rate(), b.Burst())
+	}
+	b.mu.Lock()
+	tokens := b.tokens
+	b.mu.Unlock()
+	if tokens > 1024 {
+		t.Fatalf("令牌数超过新容量 %.0f", tokens)
+	}
+}
+
+// 多个连接共享同一用户限速时的精度
+// go test -bench=BenchmarkBucketConcurrent -run=none -benchtime=1x
+func BenchmarkBucketConcurrent(b *testing.B) {
+	for _, conns := range []int{1, 64, 1024} {
+		b.Run(fmt.Sprintf("conns_%d", conns), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rate := 1024 * 1024
+				bucket := NewBucket(rate, 64*1024)
+
+				var total atomic.Int64
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				wg := sync.WaitGroup{}
+				start := time.Now()
+				for c := 0; c < conns; c++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for bucket.WaitN(ctx, 2*1024) == nil {
+							total.Add(2 * 1024)
+						}
+					}()
+				}
+				wg.Wait()
+				cancel()
+
+				// 扣除初始满桶的突发流量后计算实际速率
+				got := float64(total.Load()-64*1024) / time.Since(start).Seconds()
+				b.ReportMetric(got/float64(rate)*100, "accuracy%")
+			}
+		})
+	}
+}
+
+// 单次预约的开销
+// go test -bench=BenchmarkBucketWaitN -run=none
+func BenchmarkBucketWaitN(b *testing.B) {
+	bucket := NewBucket(0, 0)
+	ctx := context.Background()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			bucket.WaitN(ctx, 2*1024)
+		}
+	})
+}
@@ -0,0 +1,247 @@
+package rateLimit
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Scheduler 加权公平调度的令牌桶 多个Flow共享同一份速率
+// 采用开始时间公平排队(SFQ)：每次请求按 n/weight 推进所属Flow的虚拟完成时间，
+// 令牌不足时按虚拟完成时间从小到大依次放行，竞争时各Flow的吞吐与权重成正比，
+// 无竞争时任意Flow都可以用满全部速率
+type Scheduler struct {
+	mu      sync.Mutex
+	rate    float64   // 每秒生成的令牌数 小于等于0表示不限速
+	burst   float64   // 桶容量
+	tokens  float64   // 当前令牌数
+	last    time.Time // 上次结算令牌的时间
+	vtime   float64   // 虚拟时间 即最近一次放行请求的开始标签
+	seq     uint64    // 相同标签时按到达顺序放行
+	queue   waiterHeap
+	running bool          // 调度协程是否在运行
+	wake    chan struct{} // 有新的等待者或参数变更时唤醒调度协程
+}
+
+// Flow 参与调度的单个流 同一Flow的请求按到达顺序放行
+type Flow struct {
+	s      *Scheduler
+	weight float64 // 权重 受s.mu保护
+	finish float64 // 虚拟完成时间 受s.mu保护
+}
+
+type waiter struct {
+	start  float64
+	finish float64
+	seq    uint64
+	n      float64
+	ready  chan struct{}
+	index  int // 在堆中的位置 -1表示已出堆
+}
+
+type waiterHeap []*waiter
+
+func (h waiterHeap) Len() int { return len(h) }
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].finish != h[j].finish {
+		return h[i].finish < h[j].finish
+	}
+	return h[i].seq < h[j].seq
+}
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *waiterHeap) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+func (h *waiterHeap) Pop() any {
+	old := *h
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*h = old[:n-1]
+	return w
+}
+
+// NewScheduler 创建加权公平调度器 初始为满桶
+// rate小于等于0表示不限速 burst小于等于0时使用rate
+func NewScheduler(rate, burst int) *Scheduler {
+	s := &Scheduler{last: time.Now(), wake: make(chan struct{}, 1)}
+	s.setLimit(rate, burst)
+	s.tokens = s.burst
+	return s
+}
+
+func (s *Scheduler) setLimit(rate, burst int) {
+	if burst <= 0 {
+		burst = rate
+	}
+	s.rate = float64(rate)
+	s.burst = float64(burst)
+}
+
+// SetLimit 更新速率及容量 已累积的令牌不超过新容量
+func (s *Scheduler) SetLimit(rate, burst int) {
+	s.mu.Lock()
+	s.advance(time.Now())
+	s.setLimit(rate, burst)
+	if s.tokens > s.burst {
+		s.tokens = s.burst
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// advance 结算从上次到now生成的令牌
+func (s *Scheduler) advance(now time.Time) {
+	elapsed := now.Sub(s.last)
+	if elapsed <= 0 {
+		return
+	}
+	s.last = now
+	if s.rate <= 0 {
+		s.tokens = s.burst
+		return
+	}
+	s.tokens = math.Min(s.burst, s.tokens+elapsed.Seconds()*s.rate)
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NewFlow 创建参与调度的流 weight小于等于0时按1处理
+func (s *Scheduler) NewFlow(weight int) *Flow {
+	f := &Flow{s: s}
+	f.SetWeight(weight)
+	return f
+}
+
+// SetWeight 更新权重 对之后的请求生效
+func (f *Flow) SetWeight(weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	f.s.mu.Lock()
+	f.weight = float64(weight)
+	f.s.mu.Unlock()
+}
+
+// WaitN 等待获取n个令牌 ctx取消时立即返回
+// n超过桶容量时按容量分批等待
+func (f *Flow) WaitN(ctx context.Context, n int) error {
+	for n > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunk := n
+		f.s.mu.Lock()
+		if burst := int(f.s.burst); burst > 0 && chunk > burst {
+			chunk = burst
+		}
+		f.s.mu.Unlock()
+
+		if err := f.s.wait(ctx, f, float64(chunk)); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+func (s *Scheduler) wait(ctx context.Context, f *Flow, n float64) error {
+	s.mu.Lock()
+	if s.rate <= 0 {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.advance(time.Now())
+	start := math.Max(s.vtime, f.finish)
+	f.finish = start + n/f.weight
+
+	// 没有排队且令牌充足时直接放行
+	if len(s.queue) == 0 && s.tokens >= n {
+		s.tokens -= n
+		s.vtime = start
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.seq++
+	w := &waiter{start: start, finish: f.finish, seq: s.seq, n: n, ready: make(chan struct{})}
+	heap.Push(&s.queue, w)
+	if !s.running {
+		s.running = true
+		go s.dispatch()
+	}
+	s.mu.Unlock()
+	s.notify()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.index >= 0 {
+			heap.Remove(&s.queue, w.index)
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// dispatch 按虚拟完成时间依次放行等待者 队列为空时退出
+func (s *Scheduler) dispatch() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+
+		s.advance(time.Now())
+		head := s.queue[0]
+		// 容量调小后超过容量的请求在满桶时放行
+		need := math.Min(head.n, s.burst)
+		if s.rate <= 0 || s.tokens >= need {
+			heap.Pop(&s.queue)
+			if s.rate > 0 {
+				s.tokens -= head.n
+			}
+			s.vtime = head.start
+			close(head.ready)
+			s.mu.Unlock()
+			continue
+		}
+		wait := time.Duration((need - s.tokens) / s.rate * float64(time.Second))
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package rateLimit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// go test -run TestSchedulerWeight -v
+func TestSchedulerWeight(t *testing.T) {
+	s := NewScheduler(128*1024, 4*1024)
+	weights := []int{4, 2, 1}
+	totals := make([]atomic.Int64, len(weights))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	for i, weight := range weights {
+		flow := s.NewFlow(weight)
+		// 每个流多个连接并发读取
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for flow.WaitN(ctx, 1024) == nil {
+					totals[i].Add(1024)
+				}
+			}(i)
+		}
+	}
+	wg.Wait()
+
+	base := float64(totals[len(weights)-1].Load())
+	for i, weight := range weights {
+		got := float64(totals[i].Load()) / base
+		want := float64(weight) / float64(weights[len(weights)-1])
+		t.Logf("权重%d 读取%d 比例%.2f", weight, totals[i].Load(), got)
+		if got > want*1.2 || got < want*0.8 {
+			t.Fatalf("权重%d 实际比例%.2f 期望比例%.2f", weight, got, want)
+		}
+	}
+}
+
+// go test -run TestSchedulerWorkConserving -v
+func TestSchedulerWorkConserving(t *testing.T) {
+	rate := 64 * 1024
+	s := NewScheduler(rate, 4*1024)
+	s.NewFlow(8) // 空闲的高权重流不占用额度
+	flow := s.NewFlow(1)
+
+	var total atomic.Int64
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	for flow.WaitN(ctx, 2*1024) == nil {
+		total.Add(2 * 1024)
+	}
+
+	got := float64(total.Load()) / time.Since(start).Seconds()
+	if got > float64(rate)*1.15 || got < float64(rate)*0.85 {
+		t.Fatalf("实际速率%.0f 期望速率%d", got, rate)
+	}
+}
+
+// go test -run TestSchedulerCancel -v
+func TestSchedulerCancel(t *testing.T) {
+	s := NewScheduler(1024, 1024)
+	flow := s.NewFlow(1)
+	if err := flow.WaitN(context.Background(), 1024); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := flow.WaitN(ctx, 1024); err == nil {
+		t.Fatal("令牌不足时应等待至ctx取消")
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Fatalf("ctx取消后未及时返回 %v", d)
+	}
+
+	// 取消的等待者应出队 不阻塞其他流
+	other := s.NewFlow(1)
+	start = time.Now()
+	if err := other.WaitN(context.Background(), 256); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 600*time.Millisecond {
+		t.Fatalf("取消的等待者未出队 等待%v", d)
+	}
+}